@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// leaderLockName is the name of the ConfigMap holding the leader lock
+	leaderLockName = "pvc-operator-lock"
+	// operatorNamespaceEnv holds the namespace of the operator, the lock is created there
+	operatorNamespaceEnv = "OPERATOR_NAMESPACE"
+	// podNameEnv holds the name of the operator pod, it identifies the replica in the lock
+	podNameEnv = "POD_NAME"
+)
+
+// leaderTiming holds the durations of the leader election
+type leaderTiming struct {
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+var defaultLeaderTiming = leaderTiming{
+	leaseDuration: 15 * time.Second,
+	renewDeadline: 10 * time.Second,
+	retryPeriod:   2 * time.Second,
+}
+
+// errLockReleased is returned when the elector tries to write the lock after it was released
+var errLockReleased = errors.New("the leader lock was released")
+
+// runAsLeader blocks until this replica acquires the leader lock and runs the operator then.
+// The process exits when the leadership is lost so a standby replica can take over, it returns once ctx is cancelled
+// and the lock is released.
+func runAsLeader(ctx context.Context, apiClient client.Client, run func(stop <-chan struct{})) error {
+	namespace := os.Getenv(operatorNamespaceEnv)
+	if namespace == "" {
+		return fmt.Errorf("%s must be set to hold the leader lock", operatorNamespaceEnv)
+	}
+	identity := os.Getenv(podNameEnv)
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("could not determine the leader election identity: %s", err.Error())
+		}
+		identity = hostname
+	}
+	return lead(ctx, newConfigMapLock(apiClient, namespace, leaderLockName, identity), defaultLeaderTiming, run)
+}
+
+// lead runs the leader election on lock until ctx is cancelled, run is called once the lock is acquired.
+// The lock is released on cancellation so a standby replica does not have to wait for the lease to expire.
+func lead(ctx context.Context, lock *configMapLock, timing leaderTiming, run func(stop <-chan struct{})) error {
+	identity := lock.Identity()
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: timing.leaseDuration,
+		RenewDeadline: timing.renewDeadline,
+		RetryPeriod:   timing.retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				logrus.Infof("Became the leader as %s", identity)
+				run(stop)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					logrus.Infof("Stopped leading as %s", identity)
+					return
+				}
+				logrus.Fatalf("Lost the leadership as %s, exiting", identity)
+			},
+			OnNewLeader: func(leader string) {
+				logrus.Infof("Current leader is %s", leader)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Waiting to become the leader as %s", identity)
+	go elector.Run()
+	<-ctx.Done()
+	if err := lock.release(); err != nil {
+		return fmt.Errorf("could not release the leader lock: %s", err.Error())
+	}
+	return nil
+}
+
+// configMapLock is a resourcelock.Interface keeping the leader election record in an annotation of a ConfigMap like
+// resourcelock.ConfigMapLock does, but through client.Client. A released record without holder is reported as
+// missing, so the elector of a standby replica takes it over on its next retry.
+type configMapLock struct {
+	client    client.Client
+	namespace string
+	name      string
+	identity  string
+
+	mu        sync.Mutex
+	configMap *v1.ConfigMap
+	released  bool
+}
+
+func newConfigMapLock(apiClient client.Client, namespace, name, identity string) *configMapLock {
+	return &configMapLock{
+		client:    apiClient,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+	}
+}
+
+// Get returns the election record of the ConfigMap
+func (l *configMapLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	configMap := l.newConfigMap()
+	if err := l.client.Get(configMap); err != nil {
+		return nil, err
+	}
+	record, err := getRecord(configMap)
+	if err != nil {
+		return nil, err
+	}
+	l.configMap = configMap
+	if record.HolderIdentity == "" {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, l.name)
+	}
+	return record, nil
+}
+
+// Create creates the ConfigMap with the election record, a released lock is taken over
+func (l *configMapLock) Create(record resourcelock.LeaderElectionRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return errLockReleased
+	}
+	configMap := l.newConfigMap()
+	if err := setRecord(configMap, record); err != nil {
+		return err
+	}
+	err := l.client.Create(configMap)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	if l.configMap == nil {
+		return err
+	}
+	current, recordErr := getRecord(l.configMap)
+	if recordErr != nil {
+		return recordErr
+	}
+	if current.HolderIdentity != "" {
+		return err
+	}
+	return l.update(record)
+}
+
+// Update replaces the election record of the ConfigMap read by the last Get
+func (l *configMapLock) Update(record resourcelock.LeaderElectionRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.released {
+		return errLockReleased
+	}
+	return l.update(record)
+}
+
+func (l *configMapLock) update(record resourcelock.LeaderElectionRecord) error {
+	if l.configMap == nil {
+		return fmt.Errorf("the lock %s has to be read before it is updated", l.Describe())
+	}
+	configMap := l.configMap.DeepCopy()
+	if err := setRecord(configMap, record); err != nil {
+		return err
+	}
+	if err := l.client.Update(configMap); err != nil {
+		return err
+	}
+	l.configMap = configMap
+	return nil
+}
+
+// RecordEvent records an Event about the election on the ConfigMap
+func (l *configMapLock) RecordEvent(event string) {
+	events.Normal(l.newConfigMap(), events.LeaderElection, "%s %s", l.identity, event)
+}
+
+// Identity returns the identity of this replica
+func (l *configMapLock) Identity() string {
+	return l.identity
+}
+
+// Describe returns the namespace and name of the ConfigMap
+func (l *configMapLock) Describe() string {
+	return fmt.Sprintf("%s/%s", l.namespace, l.name)
+}
+
+// release clears the holder of the record if this replica holds the lock, the lock can not be written afterwards
+func (l *configMapLock) release() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = true
+	configMap := l.newConfigMap()
+	if err := l.client.Get(configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	record, err := getRecord(configMap)
+	if err != nil {
+		return err
+	}
+	if record.HolderIdentity != l.identity {
+		return nil
+	}
+	record.HolderIdentity = ""
+	record.RenewTime = metav1.Now()
+	if err := setRecord(configMap, *record); err != nil {
+		return err
+	}
+	if err := l.client.Update(configMap); err != nil {
+		return err
+	}
+	logrus.Infof("Released the leader lock %s", l.Describe())
+	return nil
+}
+
+func (l *configMapLock) newConfigMap() *v1.ConfigMap {
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      l.name,
+			Namespace: l.namespace,
+		},
+	}
+}
+
+// getRecord returns the election record in the annotation of configMap, an empty one if it has none
+func getRecord(configMap *v1.ConfigMap) (*resourcelock.LeaderElectionRecord, error) {
+	record := &resourcelock.LeaderElectionRecord{}
+	raw, ok := configMap.Annotations[resourcelock.LeaderElectionRecordAnnotationKey]
+	if !ok {
+		return record, nil
+	}
+	if err := json.Unmarshal([]byte(raw), record); err != nil {
+		return nil, fmt.Errorf("could not parse the leader election record of %s: %s", configMap.Name, err.Error())
+	}
+	return record, nil
+}
+
+// setRecord stores record in the annotation of configMap
+func setRecord(configMap *v1.ConfigMap, record resourcelock.LeaderElectionRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	configMap.Annotations[resourcelock.LeaderElectionRecordAnnotationKey] = string(raw)
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const testNamespace = "operator"
+
+var testTiming = leaderTiming{
+	leaseDuration: time.Second,
+	renewDeadline: 500 * time.Millisecond,
+	retryPeriod:   50 * time.Millisecond,
+}
+
+// candidate is a replica running the leader election in the background
+type candidate struct {
+	cancel  context.CancelFunc
+	leading chan struct{}
+	done    chan error
+}
+
+func startCandidate(apiClient client.Client, identity string) *candidate {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &candidate{cancel: cancel, leading: make(chan struct{}), done: make(chan error, 1)}
+	lock := newConfigMapLock(apiClient, testNamespace, leaderLockName, identity)
+	go func() {
+		c.done <- lead(ctx, lock, testTiming, func(stop <-chan struct{}) {
+			close(c.leading)
+		})
+	}()
+	return c
+}
+
+// isLeading tells whether the candidate becomes the leader within timeout
+func (c *candidate) isLeading(timeout time.Duration) bool {
+	select {
+	case <-c.leading:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (c *candidate) stop(t *testing.T) {
+	c.cancel()
+	if err := <-c.done; err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// lockConfigMap returns the ConfigMap of the leader lock with the election record of holder
+func lockConfigMap(holder string, renewTime time.Time) *v1.ConfigMap {
+	lock := newConfigMapLock(nil, testNamespace, leaderLockName, holder)
+	configMap := lock.newConfigMap()
+	setRecord(configMap, resourcelock.LeaderElectionRecord{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: 1,
+		AcquireTime:          metav1.NewTime(renewTime),
+		RenewTime:            metav1.NewTime(renewTime),
+	})
+	return configMap
+}
+
+// getLock returns the ConfigMap of the leader lock and its election record
+func getLock(t *testing.T, apiClient client.Client) (*v1.ConfigMap, *resourcelock.LeaderElectionRecord) {
+	configMap := newConfigMapLock(apiClient, testNamespace, leaderLockName, "").newConfigMap()
+	if err := apiClient.Get(configMap); err != nil {
+		t.Fatalf("could not get the lock: %s", err)
+	}
+	record, err := getRecord(configMap)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return configMap, record
+}
+
+func TestLeaderAcquiresLock(t *testing.T) {
+	apiClient := fake.NewClient()
+	a := startCandidate(apiClient, "a")
+	defer a.stop(t)
+
+	if !a.isLeading(testTiming.leaseDuration) {
+		t.Fatal("expected the candidate to acquire a missing lock")
+	}
+	_, record := getLock(t, apiClient)
+	if record.HolderIdentity != "a" {
+		t.Errorf("expected the lock to be held by a, got %q", record.HolderIdentity)
+	}
+	if record.LeaderTransitions != 0 {
+		t.Errorf("expected no leader transitions, got %d", record.LeaderTransitions)
+	}
+}
+
+func TestLeaderRenewsLock(t *testing.T) {
+	apiClient := fake.NewClient()
+	a := startCandidate(apiClient, "a")
+	defer a.stop(t)
+
+	if !a.isLeading(testTiming.leaseDuration) {
+		t.Fatal("expected the candidate to acquire a missing lock")
+	}
+	acquired, _ := getLock(t, apiClient)
+	time.Sleep(3 * testTiming.retryPeriod)
+	renewed, record := getLock(t, apiClient)
+	if renewed.ResourceVersion == acquired.ResourceVersion {
+		t.Error("expected the leader to renew the lock")
+	}
+	if record.HolderIdentity != "a" {
+		t.Errorf("expected the lock to be held by a, got %q", record.HolderIdentity)
+	}
+	if record.LeaderTransitions != 0 {
+		t.Errorf("expected no leader transitions on renewal, got %d", record.LeaderTransitions)
+	}
+}
+
+func TestLeaderTakesOverExpiredLock(t *testing.T) {
+	apiClient := fake.NewClient(lockConfigMap("b", time.Now().Add(-time.Hour)))
+	a := startCandidate(apiClient, "a")
+	defer a.stop(t)
+
+	if a.isLeading(testTiming.leaseDuration / 2) {
+		t.Fatal("expected the candidate to wait a lease duration before taking over")
+	}
+	if !a.isLeading(2 * testTiming.leaseDuration) {
+		t.Fatal("expected the candidate to take over a lock that is not renewed")
+	}
+	_, record := getLock(t, apiClient)
+	if record.HolderIdentity != "a" {
+		t.Errorf("expected the lock to be held by a, got %q", record.HolderIdentity)
+	}
+	if record.LeaderTransitions != 1 {
+		t.Errorf("expected a leader transition, got %d", record.LeaderTransitions)
+	}
+}
+
+func TestLeaderTakesOverReleasedLock(t *testing.T) {
+	apiClient := fake.NewClient()
+	b := startCandidate(apiClient, "b")
+	if !b.isLeading(testTiming.leaseDuration) {
+		t.Fatal("expected the candidate to acquire a missing lock")
+	}
+	a := startCandidate(apiClient, "a")
+	defer a.stop(t)
+
+	if a.isLeading(testTiming.leaseDuration * 3 / 2) {
+		t.Fatal("expected the candidate not to take over a lock that is renewed")
+	}
+	b.stop(t)
+	if _, record := getLock(t, apiClient); record.HolderIdentity != "a" && record.HolderIdentity != "" {
+		t.Errorf("expected the lock to be released, it is held by %q", record.HolderIdentity)
+	}
+	if !a.isLeading(testTiming.leaseDuration / 2) {
+		t.Fatal("expected the candidate to take over a released lock without waiting for the lease to expire")
+	}
+	_, record := getLock(t, apiClient)
+	if record.HolderIdentity != "a" {
+		t.Errorf("expected the lock to be held by a, got %q", record.HolderIdentity)
+	}
+}
+
+func TestReleaseKeepsLockOfOtherHolder(t *testing.T) {
+	apiClient := fake.NewClient(lockConfigMap("b", time.Now()))
+	lock := newConfigMapLock(apiClient, testNamespace, leaderLockName, "a")
+
+	if err := lock.release(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, record := getLock(t, apiClient); record.HolderIdentity != "b" {
+		t.Errorf("expected the lock to stay held by b, got %q", record.HolderIdentity)
+	}
+	if _, err := lock.Get(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := lock.Update(resourcelock.LeaderElectionRecord{HolderIdentity: "a"}); err != errLockReleased {
+		t.Errorf("expected a released lock to refuse updates, got %v", err)
+	}
+}
@@ -2,10 +2,22 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
 	"github.com/banzaicloud/pvc-operator/pkg/stub"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
+	"github.com/banzaicloud/pvc-operator/pkg/webhook"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"github.com/operator-framework/operator-sdk/pkg/k8sclient"
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 
@@ -18,11 +30,78 @@ func printVersion() {
 	logrus.Infof("operator-sdk Version: %v", sdkVersion.Version)
 }
 
+// watchNamespaces returns the namespaces to set up watches in, one watch of NamespaceAll covers all of them
+func watchNamespaces() []string {
+	namespaces := config.WatchNamespaces()
+	if len(namespaces) == 0 {
+		logrus.Info("Watching all namespaces")
+		return []string{metav1.NamespaceAll}
+	}
+	logrus.Infof("Watching namespaces %s", strings.Join(namespaces, ", "))
+	return namespaces
+}
+
+// shutdownContext returns a context that is cancelled when the operator receives SIGTERM or SIGINT
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		logrus.Infof("Received %s, shutting down", <-signals)
+		cancel()
+	}()
+	return ctx
+}
+
+// configReloadPeriod is how often the configuration is checked for changes
+const configReloadPeriod = 10 * time.Second
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "plan" {
+		if err := runPlan(os.Args[2:], os.Stdout); err != nil {
+			logrus.Fatalf("Plan failed: %s", err.Error())
+		}
+		return
+	}
 	printVersion()
+	configLoader := config.NewLoaderFromEnv()
+	operatorConfig, err := configLoader.Load()
+	if err != nil {
+		logrus.Fatalf("Could not load the configuration: %s", err.Error())
+	}
+	go configLoader.Watch(configReloadPeriod, wait.NeverStop)
+	go metrics.Serve(operatorConfig.MetricsAddress)
+	kubeClient := k8sclient.GetKubeClient()
+	if err := events.Init(kubeClient); err != nil {
+		logrus.Fatalf("Could not set up the Event recorder: %s", err.Error())
+	}
+	ctx := shutdownContext()
 	resyncPeriod := 0
-	sdk.Watch("banzaicloud.com/v1alpha1", "ObjectStore", metav1.NamespaceAll, resyncPeriod)
-	sdk.Watch("v1", "PersistentVolumeClaim", metav1.NamespaceAll, resyncPeriod)
-	sdk.Handle(stub.NewHandler())
-	sdk.Run(context.TODO())
+	for _, namespace := range watchNamespaces() {
+		sdk.Watch("banzaicloud.com/v1alpha1", "ObjectStore", namespace, resyncPeriod)
+		sdk.Watch("v1", "PersistentVolumeClaim", namespace, resyncPeriod)
+	}
+	sdk.Watch("storage.k8s.io/v1", "StorageClass", metav1.NamespaceAll, resyncPeriod)
+	sdk.Watch("banzaicloud.com/v1alpha1", "ProvisioningPolicy", metav1.NamespaceAll, resyncPeriod)
+	apiClient := client.NewSDKClient()
+	handler := stub.NewHandler(apiClient)
+	if err := handler.DetectProvider(); err != nil {
+		if operatorConfig.Provider != "" {
+			logrus.Fatalf("Invalid provider configured: %s", err.Error())
+		}
+		logrus.Warnf("Provider detection failed, it will be retried on the first claim: %s", err.Error())
+	}
+	if operatorConfig.Webhook.Enabled {
+		go func() {
+			logrus.Fatalf("Admission webhook server failed: %s", webhook.NewServer(handler, apiClient).Serve(operatorConfig.Webhook).Error())
+		}()
+	}
+	sdk.Handle(handler)
+	err = runAsLeader(ctx, apiClient, func(stop <-chan struct{}) {
+		go handler.Run(ctx, 1)
+		sdk.Run(ctx)
+	})
+	if err != nil {
+		logrus.Fatalf("Leader election failed: %s", err.Error())
+	}
 }
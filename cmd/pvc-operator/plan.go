@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client/memory"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/ghodss/yaml"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// runPlan implements the plan subcommand, it prints the objects the operator would create for the PVCs in a file
+// without contacting the cluster or the cloud. StorageProfiles in the file are taken into account, other kinds are
+// skipped so the output of helm template can be planned as is.
+func runPlan(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	file := flags.String("f", "", "file with the PersistentVolumeClaims to plan, - reads stdin")
+	providerName := flags.String("provider", "", fmt.Sprintf("provider to plan for, one of %s, defaults to the configured one", strings.Join(providers.RegisteredProviders(), ", ")))
+	configFile := flags.String("config", "", "configuration file of the operator, the built-in configuration is used without it")
+	flags.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("-f is required")
+	}
+	logrus.SetLevel(logrus.WarnLevel)
+
+	operatorConfig, err := config.NewFileLoader(*configFile).Load()
+	if err != nil {
+		return err
+	}
+	if *providerName == "" {
+		*providerName = operatorConfig.Provider
+	}
+	if *providerName == "" {
+		return fmt.Errorf("-provider is required when the configuration does not set one")
+	}
+	raw, err := readPlanFile(*file)
+	if err != nil {
+		return err
+	}
+	planned := memory.NewClient()
+	provider, err := providers.NewProvider(planned, *providerName)
+	if err != nil {
+		return err
+	}
+	claims, err := decodePlanFile(raw, planned)
+	if err != nil {
+		return err
+	}
+	for _, pvc := range claims {
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+			logrus.Warnf("Skipping PersistentVolumeClaim %s/%s without a StorageClass", pvc.Namespace, pvc.Name)
+			continue
+		}
+		if providers.CheckStorageClassExistence(planned, *pvc.Spec.StorageClassName) {
+			// planned for an earlier claim already
+			continue
+		}
+		profile, err := providers.FindStorageProfile(planned, *pvc.Spec.StorageClassName)
+		if err != nil {
+			return fmt.Errorf("PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		}
+		state, err := providers.Plan(planned, provider, pvc, profile)
+		if err != nil {
+			return fmt.Errorf("PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		}
+		for _, action := range state.Actions {
+			fmt.Fprintf(out, "# %s\n", action.Description)
+		}
+		for _, object := range state.Objects {
+			// Nfs StorageClasses share the provisioner, it is printed once
+			err := planned.Create(object.DeepCopyObject())
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			manifest, err := providers.RenderManifests([]sdk.Object{object})
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(out, manifest)
+		}
+	}
+	return nil
+}
+
+// readPlanFile reads the file, - reads stdin
+func readPlanFile(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// decodePlanFile returns the PVCs of the YAML documents and adds the StorageProfiles to the client
+func decodePlanFile(raw []byte, planned *memory.Client) ([]*v1.PersistentVolumeClaim, error) {
+	var claims []*v1.PersistentVolumeClaim
+	for i, document := range splitDocuments(string(raw)) {
+		typeMeta := &metav1.TypeMeta{}
+		if err := yaml.Unmarshal([]byte(document), typeMeta); err != nil {
+			return nil, fmt.Errorf("document %d: %s", i+1, err.Error())
+		}
+		switch typeMeta.Kind {
+		case "PersistentVolumeClaim":
+			pvc := &v1.PersistentVolumeClaim{}
+			if err := yaml.Unmarshal([]byte(document), pvc); err != nil {
+				return nil, fmt.Errorf("document %d: %s", i+1, err.Error())
+			}
+			if pvc.Namespace == "" {
+				pvc.Namespace = metav1.NamespaceDefault
+			}
+			claims = append(claims, pvc)
+		case "StorageProfile":
+			profile := &v1alpha1.StorageProfile{}
+			if err := yaml.Unmarshal([]byte(document), profile); err != nil {
+				return nil, fmt.Errorf("document %d: %s", i+1, err.Error())
+			}
+			if err := planned.Create(profile); err != nil {
+				return nil, fmt.Errorf("document %d: %s", i+1, err.Error())
+			}
+		}
+	}
+	return claims, nil
+}
+
+// splitDocuments splits a multi-document YAML stream, empty documents are dropped
+func splitDocuments(raw string) []string {
+	var documents []string
+	var current []string
+	flush := func() {
+		if document := strings.TrimSpace(strings.Join(current, "\n")); document != "" {
+			documents = append(documents, document)
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		if strings.HasPrefix(line, "---") && strings.TrimSpace(strings.TrimPrefix(line, "---")) == "" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return documents
+}
@@ -29,6 +29,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&ObjectStore{},
 		&ObjectStoreList{},
+		&StorageProfile{},
+		&StorageProfileList{},
+		&ProvisioningPolicy{},
+		&ProvisioningPolicyList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -1,6 +1,9 @@
 package v1alpha1
 
 import (
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,3 +35,104 @@ type ObjectStoreSpec struct {
 type ObjectStoreStatus struct {
 	// Fill me
 }
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StorageProfileList struct for lists
+type StorageProfileList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []StorageProfile `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// StorageProfile maps StorageClass names to the StorageClasses rendered on each provider
+type StorageProfile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              StorageProfileSpec `json:"spec"`
+}
+
+// StorageProfileSpec struct holds specs
+type StorageProfileSpec struct {
+	// ClassNamePattern is a regular expression matched against the StorageClass name of the PVC
+	ClassNamePattern string `json:"classNamePattern"`
+	// Priority decides between profiles matching the same name, the highest wins
+	Priority int32 `json:"priority,omitempty"`
+	// Nfs serves the matching StorageClasses from the Nfs provisioner deployed by the operator
+	Nfs bool `json:"nfs,omitempty"`
+	// Provisioners describes the StorageClass per provider and access mode
+	Provisioners []ProvisionerSpec `json:"provisioners,omitempty"`
+}
+
+// ProvisionerSpec describes the StorageClass rendered on a provider for the listed access modes
+type ProvisionerSpec struct {
+	Provider          string                            `json:"provider"`
+	AccessModes       []v1.PersistentVolumeAccessMode   `json:"accessModes"`
+	Provisioner       string                            `json:"provisioner"`
+	Parameters        map[string]string                 `json:"parameters,omitempty"`
+	ReclaimPolicy     *v1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+	MountOptions      []string                          `json:"mountOptions,omitempty"`
+	VolumeBindingMode *storagev1.VolumeBindingMode      `json:"volumeBindingMode,omitempty"`
+	// AllowVolumeExpansion overrides whether the StorageClass allows growing volumes, by default it is allowed if the
+	// provisioner supports it
+	AllowVolumeExpansion *bool `json:"allowVolumeExpansion,omitempty"`
+	// Encryption encrypts the volumes of the StorageClass at rest
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+}
+
+// EncryptionSpec describes the encryption at rest of volumes
+type EncryptionSpec struct {
+	// Key references the key the volumes are encrypted with: an AWS KMS key ARN, a Google Cloud KMS key or an Azure
+	// disk encryption set ID. Without it the default key of the provider is used.
+	Key string `json:"key,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ProvisioningPolicyList struct for lists
+type ProvisioningPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []ProvisioningPolicy `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ProvisioningPolicy restricts which namespaces may make the operator create which storage types
+type ProvisioningPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ProvisioningPolicySpec `json:"spec"`
+}
+
+// ProvisioningPolicySpec struct holds specs
+type ProvisioningPolicySpec struct {
+	Rules []ProvisioningRule `json:"rules"`
+}
+
+// StorageType is a kind of storage the operator creates for a PVC
+type StorageType string
+
+const (
+	// StorageTypeNfs is an Nfs provisioner with its StorageClass
+	StorageTypeNfs StorageType = "nfs"
+	// StorageTypeAzureStorageAccount is an Azure storage account with its AzureFile StorageClass
+	StorageTypeAzureStorageAccount StorageType = "azure-storage-account"
+	// StorageTypeStorageClass is any other StorageClass
+	StorageTypeStorageClass StorageType = "storage-class"
+)
+
+// ProvisioningRule allows the selected namespaces to trigger the listed storage types
+type ProvisioningRule struct {
+	// Namespaces selects namespaces by name
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects namespaces by label
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	StorageTypes      []StorageType         `json:"storageTypes"`
+	// MaxCount caps the number of StorageClasses of these types created for a namespace
+	MaxCount *int32 `json:"maxCount,omitempty"`
+	// MaxTotalSize caps the storage requested by the PVCs of a namespace using these types
+	MaxTotalSize *resource.Quantity `json:"maxTotalSize,omitempty"`
+}
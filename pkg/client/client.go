@@ -0,0 +1,57 @@
+package client
+
+import (
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Client is the part of the Kubernetes API the operator uses, objects have to carry their TypeMeta
+type Client interface {
+	// Get fills into with the object of the same kind, name and namespace
+	Get(into sdk.Object) error
+	// Create creates the object
+	Create(object sdk.Object) error
+	// Update replaces the object
+	Update(object sdk.Object) error
+	// Delete deletes the object
+	Delete(object sdk.Object) error
+	// List fills into with the objects in namespace matching the label selector, an empty namespace lists all of them
+	List(namespace string, into sdk.Object, labelSelector string) error
+}
+
+// NewSDKClient returns a Client backed by the operator-sdk
+func NewSDKClient() Client {
+	return &sdkClient{}
+}
+
+// sdkClient calls the API server through the operator-sdk
+type sdkClient struct {
+}
+
+// Get fills into with the object of the same kind, name and namespace
+func (c *sdkClient) Get(into sdk.Object) error {
+	return sdk.Get(into)
+}
+
+// Create creates the object
+func (c *sdkClient) Create(object sdk.Object) error {
+	return sdk.Create(object)
+}
+
+// Update replaces the object
+func (c *sdkClient) Update(object sdk.Object) error {
+	return sdk.Update(object)
+}
+
+// Delete deletes the object
+func (c *sdkClient) Delete(object sdk.Object) error {
+	return sdk.Delete(object)
+}
+
+// List fills into with the objects in namespace matching the label selector
+func (c *sdkClient) List(namespace string, into sdk.Object, labelSelector string) error {
+	if labelSelector == "" {
+		return sdk.List(namespace, into)
+	}
+	return sdk.List(namespace, into, sdk.WithListOptions(&metav1.ListOptions{LabelSelector: labelSelector}))
+}
@@ -0,0 +1,24 @@
+package fake
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/memory"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+)
+
+// Client is the in-memory client.Client of the tests
+type Client struct {
+	*memory.Client
+}
+
+// NewClient returns a Client holding copies of the given objects
+func NewClient(objects ...sdk.Object) *Client {
+	c := &Client{Client: memory.NewClient()}
+	for _, object := range objects {
+		if err := c.Create(object); err != nil {
+			panic(fmt.Sprintf("could not add %T to the fake client: %s", object, err.Error()))
+		}
+	}
+	return c
+}
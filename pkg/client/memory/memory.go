@@ -0,0 +1,175 @@
+package memory
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Client is an in-memory client.Client, objects are keyed by their TypeMeta kind, namespace and name. It backs the fake
+// client of the tests and records the objects the plan subcommand would create. Like the API server it only marks
+// objects with finalizers as being deleted.
+type Client struct {
+	mu      sync.Mutex
+	objects map[key]runtime.Object
+	version int
+}
+
+// key identifies a stored object
+type key struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// NewClient returns an empty Client
+func NewClient() *Client {
+	return &Client{objects: map[key]runtime.Object{}}
+}
+
+// Get fills into with the object of the same kind, name and namespace
+func (c *Client) Get(into sdk.Object) error {
+	k, _, err := keyOf(into)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stored, ok := c.objects[k]
+	if !ok {
+		return notFound(k)
+	}
+	return copyInto(stored, into)
+}
+
+// Create creates the object
+func (c *Client) Create(object sdk.Object) error {
+	k, accessor, err := keyOf(object)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.objects[k]; ok {
+		return apierrors.NewAlreadyExists(schema.GroupResource{Resource: k.kind}, k.name)
+	}
+	c.version++
+	accessor.SetResourceVersion(fmt.Sprintf("%d", c.version))
+	c.objects[k] = object.DeepCopyObject()
+	return nil
+}
+
+// Update replaces the object
+func (c *Client) Update(object sdk.Object) error {
+	k, accessor, err := keyOf(object)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.objects[k]; !ok {
+		return notFound(k)
+	}
+	if accessor.GetDeletionTimestamp() != nil && len(accessor.GetFinalizers()) == 0 {
+		delete(c.objects, k)
+		return nil
+	}
+	c.version++
+	accessor.SetResourceVersion(fmt.Sprintf("%d", c.version))
+	c.objects[k] = object.DeepCopyObject()
+	return nil
+}
+
+// Delete deletes the object, objects with finalizers only get a deletion timestamp
+func (c *Client) Delete(object sdk.Object) error {
+	k, _, err := keyOf(object)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stored, ok := c.objects[k]
+	if !ok {
+		return notFound(k)
+	}
+	accessor, err := meta.Accessor(stored)
+	if err != nil {
+		return err
+	}
+	if len(accessor.GetFinalizers()) == 0 {
+		delete(c.objects, k)
+		return nil
+	}
+	if accessor.GetDeletionTimestamp() == nil {
+		now := metav1.Now()
+		accessor.SetDeletionTimestamp(&now)
+	}
+	return nil
+}
+
+// List fills into with the objects in namespace matching the label selector, the kind of the items is taken
+// from the TypeMeta of the list like the operator-sdk does
+func (c *Client) List(namespace string, into sdk.Object, labelSelector string) error {
+	kind := into.GetObjectKind().GroupVersionKind().Kind
+	if kind == "" {
+		return fmt.Errorf("%T has no kind set", into)
+	}
+	selector, err := labels.Parse(labelSelector)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var items []runtime.Object
+	for k, stored := range c.objects {
+		if k.kind != kind || (namespace != "" && k.namespace != namespace) {
+			continue
+		}
+		accessor, err := meta.Accessor(stored)
+		if err != nil {
+			return err
+		}
+		if !selector.Matches(labels.Set(accessor.GetLabels())) {
+			continue
+		}
+		items = append(items, stored.DeepCopyObject())
+	}
+	return meta.SetList(into, items)
+}
+
+// keyOf returns the key of the object
+func keyOf(object sdk.Object) (key, metav1.Object, error) {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return key{}, nil, err
+	}
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	if kind == "" {
+		return key{}, nil, fmt.Errorf("%T %s has no kind set", object, accessor.GetName())
+	}
+	return key{kind: kind, namespace: accessor.GetNamespace(), name: accessor.GetName()}, accessor, nil
+}
+
+// copyInto copies the stored object into the one passed by the caller
+func copyInto(stored runtime.Object, into sdk.Object) error {
+	source := reflect.ValueOf(stored.DeepCopyObject())
+	target := reflect.ValueOf(into)
+	if source.Type() != target.Type() {
+		return fmt.Errorf("cannot copy %s into %s", source.Type(), target.Type())
+	}
+	target.Elem().Set(source.Elem())
+	return nil
+}
+
+// notFound returns the error the API server returns for a missing object
+func notFound(k key) error {
+	return apierrors.NewNotFound(schema.GroupResource{Resource: k.kind}, k.name)
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Env vars used as fallback for settings missing from the configuration file
+const (
+	nfsNamespaceEnv           = "NFS_NAMESPACE"
+	nfsImageEnv               = "NFS_IMAGE"
+	nfsCPURequestEnv          = "NFS_CPU_REQUEST"
+	nfsFallbackEnv            = "NFS_FALLBACK"
+	dryRunEnv                 = "DRY_RUN"
+	rbacEnabledEnv            = "RBAC_ENABLED"
+	nfsServiceAccountEnv      = "NFS_SERVICE_ACCOUNT_NAME"
+	ownerReferenceNameEnv     = "OWNER_REFERENCE_NAME"
+	storageClassGCPolicyEnv   = "STORAGECLASS_GC_POLICY"
+	pvcAnnotationEnv          = "PVC_SELECTOR_ANNOTATION"
+	pvcLabelSelectorEnv       = "PVC_LABEL_SELECTOR"
+	namespaceLabelSelectorEnv = "NAMESPACE_LABEL_SELECTOR"
+	metricsAddressEnv         = "METRICS_ADDRESS"
+	providerEnv               = "PROVIDER"
+	providerDetectionEnv      = "PROVIDER_DETECTION"
+	localProvisionerEnv       = "LOCAL_PROVISIONER"
+	metadataEndpointEnv       = "METADATA_ENDPOINT"
+	azureEndpointEnv          = "AZURE_RESOURCE_MANAGER_ENDPOINT"
+	googleStorageEndpointEnv  = "GOOGLE_STORAGE_ENDPOINT"
+	googleStorageNoAuthEnv    = "GOOGLE_STORAGE_WITHOUT_AUTHENTICATION"
+	webhookEnabledEnv         = "WEBHOOK_ENABLED"
+	webhookAddressEnv         = "WEBHOOK_ADDRESS"
+	webhookValidationEnv      = "WEBHOOK_VALIDATION"
+	webhookDefaultClassEnv    = "WEBHOOK_TRANSLATE_DEFAULT_CLASS"
+)
+
+// watchNamespaceEnv lists the namespaces the operator watches, it is read at startup only
+const watchNamespaceEnv = "WATCH_NAMESPACE"
+
+const (
+	// GCPolicyRetain keeps unused managed StorageClasses
+	GCPolicyRetain = "Retain"
+	// GCPolicyDelete tears down unused managed StorageClasses together with their Nfs backing resources
+	GCPolicyDelete = "Delete"
+)
+
+const (
+	// DetectionMetadata detects the provider by probing the metadata servers
+	DetectionMetadata = "metadata"
+	// DetectionNode detects the provider from the providerID and labels of the Nodes
+	DetectionNode = "node"
+	// DetectionLocal always picks the local provider, listed last it serves clusters the other strategies do not
+	// recognize
+	DetectionLocal = "local"
+)
+
+// Config holds the settings of the operator
+type Config struct {
+	Nfs       NfsConfig      `json:"nfs"`
+	Selectors SelectorConfig `json:"selectors"`
+	// Provider skips the cloud provider detection if set, e.g. azure, aws, google or local
+	Provider string `json:"provider"`
+	// ProviderDetection lists the detection strategies tried in order when Provider is not set, detection is retried
+	// with the next event if all of them fail
+	ProviderDetection []string `json:"providerDetection"`
+	// Local configures the local provider
+	Local LocalConfig `json:"local"`
+	// Endpoints overrides the cloud endpoints the providers call
+	Endpoints EndpointConfig `json:"endpoints"`
+	// StorageClassGCPolicy selects what happens to a managed StorageClass once its last PVC is gone
+	StorageClassGCPolicy string `json:"storageClassGCPolicy"`
+	// OwnerReferenceName is the Deployment of the operator set as owner of the Nfs objects
+	OwnerReferenceName string `json:"ownerReferenceName"`
+	// MetricsAddress is the address the Prometheus metrics are served on, it is read at startup only
+	MetricsAddress string `json:"metricsAddress"`
+	// Webhook configures the admission webhooks
+	Webhook WebhookConfig `json:"webhook"`
+	// DryRun reports what the operator would create or delete as Events and logs instead of doing it
+	DryRun bool `json:"dryRun"`
+}
+
+const (
+	// ValidationReject rejects PVCs the provider cannot serve
+	ValidationReject = "Reject"
+	// ValidationWarn admits PVCs the provider cannot serve and logs a warning
+	ValidationWarn = "Warn"
+)
+
+// ProviderPlaceholder is replaced with the name of the detected provider in translated StorageClass names
+const ProviderPlaceholder = "{provider}"
+
+// WebhookConfig holds the settings of the admission webhooks served by the operator, all but Classes are read at startup only
+type WebhookConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Classes translates the StorageClass names of new PVCs, the first matching translation wins
+	Classes []ClassTranslation `json:"classes"`
+	// TranslateDefaultClass applies the translations without a name to PVCs without a StorageClass or with the default
+	// StorageClass of the cluster, otherwise only portable names are translated
+	TranslateDefaultClass bool `json:"translateDefaultClass"`
+	// Validation selects what happens to PVCs whose access modes the provider cannot serve, Reject or Warn
+	Validation string `json:"validation"`
+}
+
+// ClassTranslation rewrites a portable StorageClass name to one the operator knows how to create
+type ClassTranslation struct {
+	// Name is the StorageClass name requested by the PVC, empty matches PVCs without one or with the default
+	// StorageClass of the cluster if TranslateDefaultClass is set
+	Name string `json:"name"`
+	// Provider restricts the translation to a provider, empty matches all
+	Provider string `json:"provider"`
+	// AccessModes restricts the translation to PVCs requesting one of them, empty matches all
+	AccessModes []v1.PersistentVolumeAccessMode `json:"accessModes"`
+	// StorageClassName is the new StorageClass name, ProviderPlaceholder is replaced with the provider name
+	StorageClassName string `json:"storageClassName"`
+	// NfsFallback appends -nfs to the new name if the provider cannot serve the access modes of the PVC with it
+	NfsFallback bool `json:"nfsFallback"`
+}
+
+// NfsConfig holds the settings of the Nfs provisioner deployed by the operator
+type NfsConfig struct {
+	Namespace          string                  `json:"namespace"`
+	Image              string                  `json:"image"`
+	Resources          v1.ResourceRequirements `json:"resources"`
+	RbacEnabled        bool                    `json:"rbacEnabled"`
+	ServiceAccountName string                  `json:"serviceAccountName"`
+	// Fallback serves a StorageClass with the Nfs provisioner if the provider cannot serve the access modes of the PVC
+	Fallback bool `json:"fallback"`
+}
+
+// LocalConfig holds the settings of the local provider
+type LocalConfig struct {
+	// Provisioner serves the ReadWriteOnce claims, empty picks the one shipped with the environment, e.g.
+	// k8s.io/minikube-hostpath on minikube, and rancher.io/local-path elsewhere
+	Provisioner string `json:"provisioner"`
+}
+
+// EndpointConfig overrides the cloud endpoints, e.g. to run against fake servers, empty values keep the defaults
+type EndpointConfig struct {
+	// Metadata is the base URL of the instance metadata server, http://169.254.169.254 by default
+	Metadata string `json:"metadata"`
+	// AzureResourceManager is the base URL of the Azure Resource Manager API
+	AzureResourceManager string `json:"azureResourceManager"`
+	// GoogleStorage is the base URL of the Google Cloud Storage JSON API
+	GoogleStorage string `json:"googleStorage"`
+	// GoogleStorageWithoutAuthentication sends no credentials to GoogleStorage, for servers like fakes which take none
+	GoogleStorageWithoutAuthentication bool `json:"googleStorageWithoutAuthentication"`
+}
+
+// SelectorConfig restricts the PVCs the operator acts on, every set selector has to match
+type SelectorConfig struct {
+	// Annotation names an annotation, optionally key=value, a PVC has to carry
+	Annotation string `json:"annotation"`
+	// PVCLabelSelector is a label selector the PVC labels have to match
+	PVCLabelSelector string `json:"pvcLabelSelector"`
+	// NamespaceLabelSelector is a label selector the namespace of the PVC has to match
+	NamespaceLabelSelector string `json:"namespaceLabelSelector"`
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Default())
+}
+
+// Get returns the configuration in effect, it must not be modified
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set replaces the configuration in effect
+func Set(config *Config) {
+	current.Store(config)
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Nfs: NfsConfig{
+			Namespace: "default",
+			Image:     "quay.io/kubernetes_incubator/nfs-provisioner:v1.0.9",
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")},
+			},
+		},
+		ProviderDetection:    []string{DetectionMetadata, DetectionNode},
+		StorageClassGCPolicy: GCPolicyRetain,
+		MetricsAddress:       ":8080",
+		Webhook: WebhookConfig{
+			Address:    ":8443",
+			CertFile:   "/etc/webhook/certs/tls.crt",
+			KeyFile:    "/etc/webhook/certs/tls.key",
+			Validation: ValidationReject,
+			Classes: []ClassTranslation{
+				{AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce}, StorageClassName: ProviderPlaceholder + "-standard"},
+				{StorageClassName: ProviderPlaceholder + "-shared", NfsFallback: true},
+				{Name: "shared", StorageClassName: ProviderPlaceholder + "-shared", NfsFallback: true},
+			},
+		},
+	}
+}
+
+// FromEnv returns the built-in configuration overridden by the env vars which are set
+func FromEnv() (*Config, error) {
+	config := Default()
+	setFromEnv(&config.Provider, providerEnv)
+	if value := os.Getenv(providerDetectionEnv); value != "" {
+		config.ProviderDetection = strings.Split(value, ",")
+	}
+	setFromEnv(&config.Local.Provisioner, localProvisionerEnv)
+	setFromEnv(&config.Endpoints.Metadata, metadataEndpointEnv)
+	setFromEnv(&config.Endpoints.AzureResourceManager, azureEndpointEnv)
+	setFromEnv(&config.Endpoints.GoogleStorage, googleStorageEndpointEnv)
+	if value := os.Getenv(googleStorageNoAuthEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", googleStorageNoAuthEnv, value, err.Error())
+		}
+		config.Endpoints.GoogleStorageWithoutAuthentication = parsed
+	}
+	setFromEnv(&config.Nfs.Namespace, nfsNamespaceEnv)
+	setFromEnv(&config.Nfs.Image, nfsImageEnv)
+	setFromEnv(&config.Nfs.ServiceAccountName, nfsServiceAccountEnv)
+	setFromEnv(&config.OwnerReferenceName, ownerReferenceNameEnv)
+	setFromEnv(&config.StorageClassGCPolicy, storageClassGCPolicyEnv)
+	setFromEnv(&config.Selectors.Annotation, pvcAnnotationEnv)
+	setFromEnv(&config.Selectors.PVCLabelSelector, pvcLabelSelectorEnv)
+	setFromEnv(&config.Selectors.NamespaceLabelSelector, namespaceLabelSelectorEnv)
+	setFromEnv(&config.MetricsAddress, metricsAddressEnv)
+	if value := os.Getenv(nfsCPURequestEnv); value != "" {
+		parsed, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", nfsCPURequestEnv, value, err.Error())
+		}
+		config.Nfs.Resources.Requests[v1.ResourceCPU] = parsed
+	}
+	setFromEnv(&config.Webhook.Address, webhookAddressEnv)
+	setFromEnv(&config.Webhook.Validation, webhookValidationEnv)
+	if value := os.Getenv(webhookEnabledEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", webhookEnabledEnv, value, err.Error())
+		}
+		config.Webhook.Enabled = parsed
+	}
+	if value := os.Getenv(webhookDefaultClassEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", webhookDefaultClassEnv, value, err.Error())
+		}
+		config.Webhook.TranslateDefaultClass = parsed
+	}
+	if value := os.Getenv(dryRunEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", dryRunEnv, value, err.Error())
+		}
+		config.DryRun = parsed
+	}
+	if value := os.Getenv(nfsFallbackEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", nfsFallbackEnv, value, err.Error())
+		}
+		config.Nfs.Fallback = parsed
+	}
+	if value := os.Getenv(rbacEnabledEnv); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %s", rbacEnabledEnv, value, err.Error())
+		}
+		config.Nfs.RbacEnabled = parsed
+	}
+	return config, nil
+}
+
+// WatchNamespaces returns the namespaces listed in the WATCH_NAMESPACE env var separated by commas, an empty list means
+// all namespaces
+func WatchNamespaces() []string {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, namespace := range strings.Split(os.Getenv(watchNamespaceEnv), ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// WatchesNamespace checks if the namespace is one of the WatchNamespaces
+func WatchesNamespace(namespace string) bool {
+	namespaces := WatchNamespaces()
+	if len(namespaces) == 0 {
+		return true
+	}
+	for _, watched := range namespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// setFromEnv overrides the value with the env var if it is set
+func setFromEnv(value *string, env string) {
+	if fromEnv := os.Getenv(env); fromEnv != "" {
+		*value = fromEnv
+	}
+}
+
+// Validate checks the configuration and lists every problem found
+func (c *Config) Validate() error {
+	var problems []string
+	if len(c.ProviderDetection) == 0 {
+		problems = append(problems, "providerDetection must list at least one strategy")
+	}
+	for _, strategy := range c.ProviderDetection {
+		if strategy != DetectionMetadata && strategy != DetectionNode && strategy != DetectionLocal {
+			problems = append(problems, fmt.Sprintf("providerDetection must contain %s, %s or %s, not %q", DetectionMetadata, DetectionNode, DetectionLocal, strategy))
+		}
+	}
+	if c.Nfs.Namespace == "" {
+		problems = append(problems, "nfs.namespace must be set")
+	}
+	if c.Nfs.Image == "" {
+		problems = append(problems, "nfs.image must be set")
+	}
+	if c.StorageClassGCPolicy != GCPolicyRetain && c.StorageClassGCPolicy != GCPolicyDelete {
+		problems = append(problems, fmt.Sprintf("storageClassGCPolicy must be %s or %s, not %q", GCPolicyRetain, GCPolicyDelete, c.StorageClassGCPolicy))
+	}
+	if _, err := labels.Parse(c.Selectors.PVCLabelSelector); err != nil {
+		problems = append(problems, fmt.Sprintf("selectors.pvcLabelSelector: %s", err.Error()))
+	}
+	if _, err := labels.Parse(c.Selectors.NamespaceLabelSelector); err != nil {
+		problems = append(problems, fmt.Sprintf("selectors.namespaceLabelSelector: %s", err.Error()))
+	}
+	if c.Endpoints.GoogleStorageWithoutAuthentication && c.Endpoints.GoogleStorage == "" {
+		problems = append(problems, "endpoints.googleStorageWithoutAuthentication needs endpoints.googleStorage, Google Cloud Storage requires authentication")
+	}
+	if c.MetricsAddress == "" {
+		problems = append(problems, "metricsAddress must be set")
+	}
+	if c.Webhook.Enabled && (c.Webhook.Address == "" || c.Webhook.CertFile == "" || c.Webhook.KeyFile == "") {
+		problems = append(problems, "webhook.address, webhook.certFile and webhook.keyFile must be set if the webhook is enabled")
+	}
+	if c.Webhook.Validation != ValidationReject && c.Webhook.Validation != ValidationWarn {
+		problems = append(problems, fmt.Sprintf("webhook.validation must be %s or %s, not %q", ValidationReject, ValidationWarn, c.Webhook.Validation))
+	}
+	for i, translation := range c.Webhook.Classes {
+		if translation.StorageClassName == "" {
+			problems = append(problems, fmt.Sprintf("webhook.classes[%d].storageClassName must be set", i))
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// useEnv sets the env vars and returns a function restoring their previous values
+func useEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	defer useEnv(map[string]string{
+		providerEnv:          "aws",
+		providerDetectionEnv: "node,local",
+		nfsNamespaceEnv:      "storage",
+		nfsCPURequestEnv:     "500m",
+		dryRunEnv:            "true",
+		webhookEnabledEnv:    "1",
+	})()
+	config, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Provider != "aws" {
+		t.Errorf("got provider %q instead of aws", config.Provider)
+	}
+	if strings.Join(config.ProviderDetection, ",") != "node,local" {
+		t.Errorf("got provider detection %v instead of node,local", config.ProviderDetection)
+	}
+	if config.Nfs.Namespace != "storage" {
+		t.Errorf("got nfs namespace %q instead of storage", config.Nfs.Namespace)
+	}
+	if cpu := config.Nfs.Resources.Requests[v1.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("got nfs cpu request %s instead of 500m", cpu.String())
+	}
+	if !config.DryRun || !config.Webhook.Enabled {
+		t.Errorf("got dryRun %t and webhook.enabled %t instead of true", config.DryRun, config.Webhook.Enabled)
+	}
+	if config.Nfs.Image != Default().Nfs.Image {
+		t.Errorf("got nfs image %q instead of the default %q", config.Nfs.Image, Default().Nfs.Image)
+	}
+}
+
+func TestFromEnvInvalid(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+	}{
+		{env: dryRunEnv, value: "maybe"},
+		{env: nfsFallbackEnv, value: "yes please"},
+		{env: webhookDefaultClassEnv, value: "on"},
+		{env: nfsCPURequestEnv, value: "a lot"},
+	}
+	for _, test := range tests {
+		restore := useEnv(map[string]string{test.env: test.value})
+		_, err := FromEnv()
+		restore()
+		if err == nil || !strings.Contains(err.Error(), test.env) {
+			t.Errorf("%s=%s: expected an error naming the env var, got %v", test.env, test.value, err)
+		}
+	}
+}
+
+func TestParseOverridesEnv(t *testing.T) {
+	defer useEnv(map[string]string{
+		providerEnv:     "aws",
+		nfsNamespaceEnv: "storage",
+	})()
+	config, err := parse([]byte("provider: google\nnfs:\n  image: nfs:latest\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Provider != "google" {
+		t.Errorf("got provider %q, the file has to override the env var", config.Provider)
+	}
+	if config.Nfs.Namespace != "storage" {
+		t.Errorf("got nfs namespace %q, settings missing from the file have to fall back to the env var", config.Nfs.Namespace)
+	}
+	if config.Nfs.Image != "nfs:latest" {
+		t.Errorf("got nfs image %q instead of nfs:latest", config.Nfs.Image)
+	}
+	if _, err := parse([]byte("nfs: [")); err == nil {
+		t.Error("expected an error for a file which is not YAML")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(config *Config)
+		problem   string
+	}{
+		{name: "default", configure: func(config *Config) {}},
+		{name: "no detection", configure: func(config *Config) { config.ProviderDetection = nil }, problem: "providerDetection"},
+		{name: "unknown detection", configure: func(config *Config) { config.ProviderDetection = []string{"guess"} }, problem: `"guess"`},
+		{name: "nfs namespace", configure: func(config *Config) { config.Nfs.Namespace = "" }, problem: "nfs.namespace"},
+		{name: "gc policy", configure: func(config *Config) { config.StorageClassGCPolicy = "Keep" }, problem: "storageClassGCPolicy"},
+		{name: "label selector", configure: func(config *Config) { config.Selectors.PVCLabelSelector = "app in (" }, problem: "selectors.pvcLabelSelector"},
+		{name: "google storage", configure: func(config *Config) { config.Endpoints.GoogleStorageWithoutAuthentication = true }, problem: "endpoints.googleStorageWithoutAuthentication"},
+		{name: "webhook address", configure: func(config *Config) {
+			config.Webhook.Enabled = true
+			config.Webhook.Address = ""
+		}, problem: "webhook.address"},
+		{name: "webhook validation", configure: func(config *Config) { config.Webhook.Validation = "Ignore" }, problem: "webhook.validation"},
+		{name: "class translation", configure: func(config *Config) { config.Webhook.Classes = []ClassTranslation{{Name: "shared"}} }, problem: "webhook.classes[0]"},
+	}
+	for _, test := range tests {
+		config := Default()
+		test.configure(config)
+		err := config.Validate()
+		if test.problem == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.problem) {
+			t.Errorf("%s: expected an error about %s, got %v", test.name, test.problem, err)
+		}
+	}
+}
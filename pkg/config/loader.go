@@ -0,0 +1,141 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// configFileEnv holds the path of the configuration file
+	configFileEnv = "CONFIG_FILE"
+	// configMapEnv holds the name of the ConfigMap in the operator namespace holding the configuration
+	configMapEnv = "CONFIG_MAP"
+	// operatorNamespaceEnv holds the namespace of the operator
+	operatorNamespaceEnv = "OPERATOR_NAMESPACE"
+	// configMapKey is the key of the configuration in the ConfigMap
+	configMapKey = "config.yaml"
+)
+
+// Loader reads the configuration from a file or a ConfigMap, settings missing from it fall back to env vars
+type Loader struct {
+	file      string
+	configMap string
+	namespace string
+	last      []byte
+}
+
+// NewLoaderFromEnv creates a Loader reading the file or ConfigMap named by the CONFIG_FILE or CONFIG_MAP env vars.
+// Without them only env vars are used.
+func NewLoaderFromEnv() *Loader {
+	return &Loader{
+		file:      os.Getenv(configFileEnv),
+		configMap: os.Getenv(configMapEnv),
+		namespace: os.Getenv(operatorNamespaceEnv),
+	}
+}
+
+// NewFileLoader creates a Loader reading the file, settings missing from it fall back to env vars
+func NewFileLoader(file string) *Loader {
+	return &Loader{file: file}
+}
+
+// Load reads, validates and applies the configuration
+func (l *Loader) Load() (*Config, error) {
+	raw, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	config, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	l.last = raw
+	Set(config)
+	return config, nil
+}
+
+// Watch reloads the configuration every interval until stop is closed. Invalid changes are logged
+// and the configuration in effect is kept.
+func (l *Loader) Watch(interval time.Duration, stop <-chan struct{}) {
+	if l.file == "" && l.configMap == "" {
+		return
+	}
+	wait.Until(l.reload, interval, stop)
+}
+
+// reload applies the configuration if it changed since the last read
+func (l *Loader) reload() {
+	raw, err := l.read()
+	if err != nil {
+		logrus.Errorf("Could not read the configuration: %s", err.Error())
+		return
+	}
+	if bytes.Equal(raw, l.last) {
+		return
+	}
+	l.last = raw
+	config, err := parse(raw)
+	if err != nil {
+		logrus.Errorf("Keeping the current configuration: %s", err.Error())
+		return
+	}
+	Set(config)
+	logrus.Info("Configuration reloaded")
+}
+
+// read returns the raw configuration, a missing ConfigMap counts as empty
+func (l *Loader) read() ([]byte, error) {
+	switch {
+	case l.file != "":
+		raw, err := ioutil.ReadFile(l.file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read configuration file %s: %s", l.file, err.Error())
+		}
+		return raw, nil
+	case l.configMap != "":
+		configMap := &v1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ConfigMap",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.configMap,
+				Namespace: l.namespace,
+			},
+		}
+		if err := sdk.Get(configMap); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("could not get configuration ConfigMap %s: %s", l.configMap, err.Error())
+		}
+		return []byte(configMap.Data[configMapKey]), nil
+	}
+	return nil, nil
+}
+
+// parse applies the raw configuration over the env vars and validates the result
+func parse(raw []byte) (*Config, error) {
+	config, err := FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("could not parse configuration: %s", err.Error())
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
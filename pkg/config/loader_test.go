@@ -0,0 +1,73 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+)
+
+func TestLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pvc-operator-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer Set(Default())
+	file := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("storageClassGCPolicy: Delete\n")
+	loader := NewFileLoader(file)
+	if _, err := loader.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if Get().StorageClassGCPolicy != GCPolicyDelete {
+		t.Fatalf("got storageClassGCPolicy %q after loading instead of %s", Get().StorageClassGCPolicy, GCPolicyDelete)
+	}
+
+	write("storageClassGCPolicy: Retain\ndryRun: true\n")
+	loader.reload()
+	if Get().StorageClassGCPolicy != GCPolicyRetain || !Get().DryRun {
+		t.Errorf("the changed file was not applied, got storageClassGCPolicy %q and dryRun %t", Get().StorageClassGCPolicy, Get().DryRun)
+	}
+
+	write("storageClassGCPolicy: Sometimes\n")
+	loader.reload()
+	if Get().StorageClassGCPolicy != GCPolicyRetain || !Get().DryRun {
+		t.Errorf("an invalid change replaced the configuration, got storageClassGCPolicy %q and dryRun %t", Get().StorageClassGCPolicy, Get().DryRun)
+	}
+
+	os.Remove(file)
+	loader.reload()
+	if !Get().DryRun {
+		t.Error("an unreadable file replaced the configuration")
+	}
+}
+
+func TestLoaderInvalidFile(t *testing.T) {
+	if _, err := NewFileLoader(filepath.Join(os.TempDir(), "pvc-operator-missing.yaml")).Load(); err == nil {
+		t.Error("expected an error for a missing configuration file")
+	}
+}
+
+func TestShippedConfigMap(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("..", "..", "deploy", "configmap.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap := &v1.ConfigMap{}
+	if err := yaml.Unmarshal(raw, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse([]byte(configMap.Data[configMapKey])); err != nil {
+		t.Errorf("the shipped configuration is invalid: %s", err.Error())
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// namespace prefixes every metric of the operator
+const namespace = "pvc_operator"
+
+var (
+	// EventsHandled counts the watch events handled per object kind
+	EventsHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_handled_total",
+		Help:      "Number of watch events handled by object kind.",
+	}, []string{"kind"})
+	// SkippedClaims counts the PVCs not matching the configured selectors
+	SkippedClaims = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "skipped_claims_total",
+		Help:      "Number of PersistentVolumeClaims skipped because they do not match the selectors.",
+	})
+	// StorageClassesCreated counts the created StorageClasses per provider and provisioner
+	StorageClassesCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storageclasses_created_total",
+		Help:      "Number of StorageClasses created by provider and provisioner.",
+	}, []string{"provider", "provisioner"})
+	// NfsStacksDeployed counts the deployed Nfs provisioners
+	NfsStacksDeployed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nfs_stacks_deployed_total",
+		Help:      "Number of Nfs provisioner deployments created.",
+	})
+	// BucketsCreated counts the created ObjectStore buckets per provider
+	BucketsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "buckets_created_total",
+		Help:      "Number of ObjectStore buckets created by provider.",
+	}, []string{"provider"})
+	// Errors counts the failures per Event reason
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Number of failures by reason.",
+	}, []string{"reason"})
+	// CloudAPIDuration observes the latency of cloud API calls
+	CloudAPIDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_duration_seconds",
+		Help:      "Latency of cloud API calls by provider and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"provider", "operation"})
+	// MetadataProbeDuration observes the latency of metadata server probes
+	MetadataProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "metadata_probe_duration_seconds",
+		Help:      "Latency of metadata server probes by provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+	// ProviderDetections counts the provider detections per detected provider and detection strategy
+	ProviderDetections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_detections_total",
+		Help:      "Number of cloud provider detections by provider and detection strategy.",
+	}, []string{"provider", "strategy"})
+	// DryRunChanges counts the changes skipped in dry-run mode per object kind, Action for the other changes
+	DryRunChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dry_run_changes_total",
+		Help:      "Number of changes not applied in dry-run mode by object kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		EventsHandled,
+		SkippedClaims,
+		StorageClassesCreated,
+		NfsStacksDeployed,
+		BucketsCreated,
+		Errors,
+		CloudAPIDuration,
+		MetadataProbeDuration,
+		ProviderDetections,
+		DryRunChanges,
+	)
+}
+
+// ObserveSince records the time elapsed since start in the histogram
+func ObserveSince(histogram *prometheus.HistogramVec, start time.Time, labelValues ...string) {
+	histogram.WithLabelValues(labelValues...).Observe(time.Since(start).Seconds())
+}
+
+// Serve exposes the metrics on /metrics at the given address, it blocks until the server fails
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logrus.Infof("Serving metrics on %s/metrics", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logrus.Errorf("Metrics server stopped: %s", err.Error())
+	}
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// component is the source of the Events recorded by the operator
+const component = "pvc-operator"
+
+// Reasons of the Events recorded by the operator
+const (
+	ProviderDetected         = "ProviderDetected"
+	StorageClassCreated      = "StorageClassCreated"
+	NfsProvisionerCreated    = "NfsProvisionerCreated"
+	NfsFallback              = "NfsFallback"
+	StorageAccountCreated    = "StorageAccountCreated"
+	BucketCreated            = "BucketCreated"
+	AccessModeNotSupported   = "AccessModeNotSupported"
+	ProvisioningFailed       = "ProvisioningFailed"
+	ProvisioningRefused      = "ProvisioningRefused"
+	BucketCreationFailed     = "BucketCreationFailed"
+	DryRun                   = "DryRun"
+	NfsVolumeExpanded        = "NfsVolumeExpanded"
+	NfsVolumeExpansionFailed = "NfsVolumeExpansionFailed"
+	TierMismatch             = "TierMismatch"
+	UnknownTier              = "UnknownTier"
+	EncryptionKeyInvalid     = "EncryptionKeyInvalid"
+	LeaderElection           = "LeaderElection"
+)
+
+var recorder record.EventRecorder
+
+// Init starts sending the recorded Events to the API server
+func Init(kubeClient kubernetes.Interface) error {
+	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		return fmt.Errorf("could not register ObjectStore for Events: %s", err.Error())
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: component})
+	return nil
+}
+
+// Recorder returns the EventRecorder of the operator, it is nil until Init is called
+func Recorder() record.EventRecorder {
+	return recorder
+}
+
+// Normal records an informational Event on the object
+func Normal(object runtime.Object, reason, messageFmt string, args ...interface{}) {
+	logrus.Infof(messageFmt, args...)
+	if recorder != nil {
+		recorder.Eventf(object, v1.EventTypeNormal, reason, messageFmt, args...)
+	}
+}
+
+// Warning records an Event about a failure on the object
+func Warning(object runtime.Object, reason, messageFmt string, args ...interface{}) {
+	logrus.Warnf(messageFmt, args...)
+	metrics.Errors.WithLabelValues(reason).Inc()
+	if recorder != nil {
+		recorder.Eventf(object, v1.EventTypeWarning, reason, messageFmt, args...)
+	}
+}
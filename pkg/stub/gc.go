@@ -0,0 +1,118 @@
+package stub
+
+import (
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gcEnabled checks if unused managed StorageClasses should be deleted
+func gcEnabled() bool {
+	return config.Get().StorageClassGCPolicy == config.GCPolicyDelete
+}
+
+// reconcileStorageClass grows the backing PVC of a managed Nfs StorageClass with its consumers and releases a managed
+// StorageClass once no PVC uses it anymore. Classes are deleted only if the Delete policy is set or someone already
+// asked for their deletion.
+func (h *Handler) reconcileStorageClass(name string) (bool, error) {
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if err := h.client.Get(storageClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !providers.IsManagedStorageClass(storageClass) {
+		return false, nil
+	}
+	if storageClass.Provisioner == providers.NfsProvisioner && storageClass.DeletionTimestamp == nil {
+		if err := h.expandNfsDataClaim(storageClass); err != nil {
+			return false, err
+		}
+	}
+	if storageClass.DeletionTimestamp == nil && !gcEnabled() {
+		return false, nil
+	}
+	consumers, err := providers.CountStorageClassConsumers(h.client, name)
+	if err != nil {
+		return false, err
+	}
+	if consumers > 0 {
+		logrus.Infof("StorageClass %s is still used by %d PersistentVolumeClaims", name, consumers)
+		return false, nil
+	}
+	if config.Get().DryRun {
+		events.Normal(storageClass, events.DryRun, "dry run, would release unused StorageClass %s", name)
+		return false, nil
+	}
+	if storageClass.Provisioner == providers.NfsProvisioner {
+		if err := providers.TearDownNfsProvisioner(h.client, name); err != nil {
+			return false, err
+		}
+	}
+	logrus.Infof("Releasing unused StorageClass %s", name)
+	return false, providers.ReleaseStorageClass(h.client, storageClass)
+}
+
+// expandNfsDataClaim grows the backing PVC of the Nfs StorageClass to cover the storage requested by its consumers
+func (h *Handler) expandNfsDataClaim(storageClass *storagev1.StorageClass) error {
+	dataClaim, err := providers.ExpandedNfsDataClaim(h.client, storageClass.Name)
+	if err != nil || dataClaim == nil {
+		return err
+	}
+	size := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+	className, expandable, err := providers.ExpandableClaim(h.client, dataClaim)
+	if err != nil {
+		return err
+	}
+	key := dataClaim.Namespace + "/" + dataClaim.Name
+	if !expandable {
+		// the API server rejects the update until the StorageClass changes, retrying would not help
+		if h.markExpansionRefused(key) {
+			events.Warning(storageClass, events.NfsVolumeExpansionFailed, "could not expand PersistentVolumeClaim %s/%s to %s: StorageClass %q does not allow volume expansion", dataClaim.Namespace, dataClaim.Name, size.String(), className)
+		}
+		return nil
+	}
+	h.clearExpansionRefused(key)
+	if config.Get().DryRun {
+		events.Normal(storageClass, events.DryRun, "dry run, would expand PersistentVolumeClaim %s/%s to %s", dataClaim.Namespace, dataClaim.Name, size.String())
+		return nil
+	}
+	if err := h.client.Update(dataClaim); err != nil {
+		events.Warning(storageClass, events.NfsVolumeExpansionFailed, "could not expand PersistentVolumeClaim %s/%s to %s: %s", dataClaim.Namespace, dataClaim.Name, size.String(), err.Error())
+		return err
+	}
+	events.Normal(storageClass, events.NfsVolumeExpanded, "expanded PersistentVolumeClaim %s/%s to %s", dataClaim.Namespace, dataClaim.Name, size.String())
+	return nil
+}
+
+// markExpansionRefused remembers that the backing PVC cannot be grown, it returns false if it was already known
+func (h *Handler) markExpansionRefused(key string) bool {
+	h.expansionRefusedMu.Lock()
+	defer h.expansionRefusedMu.Unlock()
+	if h.expansionRefused[key] {
+		return false
+	}
+	h.expansionRefused[key] = true
+	return true
+}
+
+// clearExpansionRefused forgets that the backing PVC could not be grown
+func (h *Handler) clearExpansionRefused(key string) {
+	h.expansionRefusedMu.Lock()
+	defer h.expansionRefusedMu.Unlock()
+	delete(h.expansionRefused, key)
+}
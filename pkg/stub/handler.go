@@ -1,72 +1,272 @@
 package stub
 
 import (
+	"errors"
 	"fmt"
+	"sync"
+
 	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
 	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"strings"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/workqueue"
 )
 
-func NewHandler() sdk.Handler {
-	return &Handler{}
+// errRefused tells that a ProvisioningPolicy refused the PVC, it is not retried until the PVC or a ProvisioningPolicy
+// changes
+var errRefused = errors.New("refused by ProvisioningPolicy")
+
+// errUnservable tells that the PVC cannot be served as it is, it is not retried until the PVC changes
+var errUnservable = errors.New("cannot be served as requested")
+
+// NewHandler creates a Handler reaching the API server through kubeClient, with empty PersistentVolumeClaim and
+// StorageClass work queues
+func NewHandler(kubeClient client.Client) *Handler {
+	return &Handler{
+		client: kubeClient,
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(baseRetryDelay, maxRetryDelay),
+			"persistentvolumeclaims",
+		),
+		storageClassQueue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(baseRetryDelay, maxRetryDelay),
+			"storageclasses",
+		),
+		refused:          map[string]bool{},
+		expansionRefused: map[string]bool{},
+	}
 }
 
+// Handler turns watch events into work queue items and provisions storage for them
 type Handler struct {
-	// Fill me
+	client            client.Client
+	queue             workqueue.RateLimitingInterface
+	storageClassQueue workqueue.RateLimitingInterface
+
+	providerMu sync.Mutex
+	provider   providers.CommonProvider
+
+	// refused holds the keys of the PVCs refused by a ProvisioningPolicy, they are checked again when one changes
+	refusedMu sync.Mutex
+	refused   map[string]bool
+
+	// expansionRefused holds the keys of the backing PVCs whose StorageClass does not allow growing them, the Warning
+	// is recorded once per PVC
+	expansionRefusedMu sync.Mutex
+	expansionRefused   map[string]bool
+}
+
+// DetectProvider resolves the cloud provider ahead of the first event
+func (h *Handler) DetectProvider() error {
+	_, err := h.Provider()
+	return err
 }
 
+// Provider returns the cached cloud provider, it is resolved again if the previous detection failed or
+// the configured provider changed
+func (h *Handler) Provider() (providers.CommonProvider, error) {
+	h.providerMu.Lock()
+	defer h.providerMu.Unlock()
+	operatorConfig := config.Get()
+	override := operatorConfig.Provider
+	if h.provider != nil && (override == "" || override == h.provider.Name()) {
+		return h.provider, nil
+	}
+	provider, err := providers.ResolveProvider(h.client, override, operatorConfig.ProviderDetection)
+	if err != nil {
+		return nil, err
+	}
+	h.provider = provider
+	return provider, nil
+}
+
+// Handle enqueues pending PersistentVolumeClaims, the StorageClasses of the claims, the claims refused before a
+// ProvisioningPolicy changed and handles ObjectStore events
 func (h *Handler) Handle(ctx sdk.Context, event sdk.Event) error {
 	switch o := event.Object.(type) {
 	case *v1.PersistentVolumeClaim:
-		if o.Spec.StorageClassName != nil {
-			if o.Status.Phase == v1.ClaimPending {
-				logrus.Info("PersistenVolumeClaim event received!")
-				logrus.Info("Check if the storageclass already exist!")
-				if strings.Contains(*o.Spec.StorageClassName, "nfs") {
-					logrus.Info("Check if the deployment for Nfs exists!")
-					if !providers.CheckNfsServerExistence(*o.Spec.StorageClassName, o.Namespace) {
-						err := providers.SetUpNfsProvisioner(o)
-						if err != nil {
-							logrus.Errorf("Cloud not create the NFS deployment %s", err.Error())
-							return err
-						}
-					}
-					return nil
-				}
-				if !providers.CheckStorageClassExistence(*o.Spec.StorageClassName) {
-					commonProvider, err := providers.DetermineProvider()
-					if err != nil {
-						logrus.Errorf("Cloud not determine cloud provider %s", err.Error())
-						return err
-					}
-					if err := commonProvider.GenerateMetadata(); err != nil {
-						logrus.Errorf("Cloud not generate metadata %s", err.Error())
-						return err
-					}
-					if err := commonProvider.CreateStorageClass(o); err != nil && !apierrors.IsAlreadyExists(err) {
-						logrus.Errorf("Failed to create a storageclass: %s", err.Error())
-						return fmt.Errorf("failed to create storageclass: %s", err.Error())
-					}
-					return nil
-				}
-			}
+		metrics.EventsHandled.WithLabelValues("PersistentVolumeClaim").Inc()
+		if o.Spec.StorageClassName == nil {
+			return nil
+		}
+		if event.Deleted || o.DeletionTimestamp != nil {
+			logrus.Infof("PersistentVolumeClaim %s/%s deleted, checking StorageClass %s", o.Namespace, o.Name, *o.Spec.StorageClassName)
+			h.enqueueStorageClass(*o.Spec.StorageClassName)
+			return nil
+		}
+		if o.Status.Phase == v1.ClaimPending {
+			logrus.Info("PersistenVolumeClaim event received!")
+			h.enqueue(o)
+		}
+		// new consumers of an Nfs StorageClass expand its backing PVC
+		h.enqueueStorageClass(*o.Spec.StorageClassName)
+	case *v1alpha1.ProvisioningPolicy:
+		metrics.EventsHandled.WithLabelValues("ProvisioningPolicy").Inc()
+		logrus.Infof("ProvisioningPolicy %s changed, checking refused PersistentVolumeClaims again", o.Name)
+		h.requeueRefused()
+	case *storagev1.StorageClass:
+		metrics.EventsHandled.WithLabelValues("StorageClass").Inc()
+		if !event.Deleted && providers.IsManagedStorageClass(o) && o.DeletionTimestamp != nil {
+			logrus.Infof("Managed StorageClass %s is being deleted", o.Name)
+			h.enqueueStorageClass(o.Name)
 		}
 	case *v1alpha1.ObjectStore:
+		metrics.EventsHandled.WithLabelValues("ObjectStore").Inc()
+		if event.Deleted {
+			return nil
+		}
 		logrus.Info("Object Store creation event received!")
 		logrus.Info("Check of the bucket already exists!")
-		commonProvider, err := providers.DetermineProvider()
+		commonProvider, err := h.Provider()
+		if err != nil {
+			events.Warning(o, events.ProvisioningFailed, "could not determine cloud provider: %s", err.Error())
+			return err
+		}
+		events.Normal(o, events.ProviderDetected, "provider detected as %s", commonProvider.Name())
+		state, err := commonProvider.DesiredBucket(o)
+		if err == nil {
+			err = h.apply(o, state)
+		}
+		if err != nil {
+			events.Warning(o, events.BucketCreationFailed, "could not create bucket %s: %s", o.Spec.Name, err.Error())
+		}
+		return nil
+	}
+	return nil
+}
+
+// provision creates the StorageClass or the Nfs stack the PVC is waiting for
+func (h *Handler) provision(o *v1.PersistentVolumeClaim) error {
+	logrus.Info("Check if the storageclass already exist!")
+	profile, err := providers.FindStorageProfile(h.client, *o.Spec.StorageClassName)
+	if err != nil {
+		events.Warning(o, events.ProvisioningFailed, "could not find a StorageProfile: %s", err.Error())
+		return err
+	}
+	if profile.Spec.Nfs {
+		logrus.Info("Check if the deployment for Nfs exists!")
+		if !providers.CheckNfsServerExistence(h.client, *o.Spec.StorageClassName, config.Get().Nfs.Namespace) {
+			if err := h.allowedByPolicy(o, v1alpha1.StorageTypeNfs); err != nil {
+				return err
+			}
+			if err := h.apply(o, providers.NfsState(h.client, o)); err != nil {
+				events.Warning(o, events.ProvisioningFailed, "could not create the Nfs provisioner: %s", err.Error())
+				return err
+			}
+		}
+		return nil
+	}
+	if !providers.CheckStorageClassExistence(h.client, *o.Spec.StorageClassName) {
+		if providers.MissingTierSuffix(o) {
+			// claims of other tiers using the same name would bind to this class
+			tier := o.Annotations[providers.TierAnnotation]
+			events.Warning(o, events.TierMismatch, "refusing to create StorageClass %s for tier %s, request StorageClass %s or enable the mutating webhook to rename it",
+				*o.Spec.StorageClassName, tier, providers.TierClassName(*o.Spec.StorageClassName, tier))
+			return errUnservable
+		}
+		commonProvider, err := h.Provider()
 		if err != nil {
-			logrus.Errorf("Cloud not determine cloud provider %s", err.Error())
+			events.Warning(o, events.ProvisioningFailed, "could not determine cloud provider: %s", err.Error())
+			return err
+		}
+		events.Normal(o, events.ProviderDetected, "provider detected as %s", commonProvider.Name())
+		if err := commonProvider.GenerateMetadata(); err != nil {
+			events.Warning(o, events.ProvisioningFailed, "could not generate %s metadata: %s", commonProvider.Name(), err.Error())
 			return err
 		}
-		if err := commonProvider.CreateObjectStoreBucket(o); err != nil {
-			logrus.Errorf("Could not create an ObjectStore Bucket %s", err.Error())
+		storageType, err := providers.StorageTypeFor(commonProvider, o, profile)
+		if accessModeErr, ok := err.(*providers.UnsupportedAccessModeError); ok && config.Get().Nfs.Fallback {
+			return h.fallBackToNfs(o, accessModeErr)
+		}
+		if err != nil {
+			return storageClassFailed(o, err)
+		}
+		if err := h.allowedByPolicy(o, storageType); err != nil {
+			return err
+		}
+		state, err := commonProvider.DesiredStorageClass(o, profile)
+		if err != nil {
+			return storageClassFailed(o, err)
+		}
+		if err := h.apply(o, state); err != nil {
+			return storageClassFailed(o, err)
 		}
 		return nil
 	}
+	if classTier, mismatch := providers.TierMismatch(h.client, o); mismatch {
+		events.Warning(o, events.TierMismatch, "StorageClass %s was created for tier %q, not for the requested %s, use StorageClass %s instead",
+			*o.Spec.StorageClassName, classTier, o.Annotations[providers.TierAnnotation], providers.TierClassName(*o.Spec.StorageClassName, o.Annotations[providers.TierAnnotation]))
+		return errUnservable
+	}
+	if reason, mismatch := providers.EncryptionMismatch(h.client, o); mismatch {
+		events.Warning(o, events.EncryptionKeyInvalid, "%s", reason)
+		return errUnservable
+	}
 	return nil
 }
+
+// fallBackToNfs serves the StorageClass of the PVC with the Nfs provisioner as the provider cannot serve its access modes
+func (h *Handler) fallBackToNfs(o *v1.PersistentVolumeClaim, reason *providers.UnsupportedAccessModeError) error {
+	if err := h.allowedByPolicy(o, v1alpha1.StorageTypeNfs); err != nil {
+		return err
+	}
+	logrus.Infof("Falling back to Nfs for StorageClass %s: %s", *o.Spec.StorageClassName, reason.Error())
+	events.Normal(o, events.NfsFallback, "serving StorageClass %s with Nfs: %s", *o.Spec.StorageClassName, reason.Error())
+	if err := h.apply(o, providers.NfsFallbackState(h.client, o, reason.Error())); err != nil {
+		events.Warning(o, events.ProvisioningFailed, "could not create the Nfs provisioner: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// apply creates the desired state, in dry-run mode it is only reported on the requester
+func (h *Handler) apply(requester runtime.Object, state *providers.DesiredState) error {
+	if config.Get().DryRun {
+		providers.Report(state, requester)
+		return nil
+	}
+	return providers.Apply(h.client, state, requester)
+}
+
+// allowedByPolicy checks the ProvisioningPolicies and records a Warning if they refuse the PVC, errRefused is
+// returned then
+func (h *Handler) allowedByPolicy(o *v1.PersistentVolumeClaim, storageType v1alpha1.StorageType) error {
+	allowed, reason, err := h.checkProvisioningPolicy(o, storageType)
+	if err != nil {
+		events.Warning(o, events.ProvisioningFailed, "could not check ProvisioningPolicies: %s", err.Error())
+		return err
+	}
+	if !allowed {
+		events.Warning(o, events.ProvisioningRefused, "refusing to create %s for StorageClass %s: %s", storageType, *o.Spec.StorageClassName, reason)
+		return errRefused
+	}
+	return nil
+}
+
+// storageClassFailed records why the StorageClass of the PVC could not be created, errUnservable is returned if the
+// claim has to change before it can be served
+func storageClassFailed(o *v1.PersistentVolumeClaim, err error) error {
+	if tierErr, ok := err.(*providers.TierError); ok {
+		reason := events.TierMismatch
+		if tierErr.Provisioner == "" {
+			reason = events.UnknownTier
+		}
+		events.Warning(o, reason, "StorageClass %s: %s", *o.Spec.StorageClassName, tierErr.Error())
+		return errUnservable
+	}
+	if accessModeErr, ok := err.(*providers.UnsupportedAccessModeError); ok {
+		events.Warning(o, events.AccessModeNotSupported, "%s", accessModeErr.Error())
+	} else if encryptionErr, ok := err.(*providers.EncryptionKeyError); ok {
+		events.Warning(o, events.EncryptionKeyInvalid, "StorageClass %s: %s", *o.Spec.StorageClassName, encryptionErr.Error())
+	} else {
+		events.Warning(o, events.ProvisioningFailed, "failed to create StorageClass %s: %s", *o.Spec.StorageClassName, err.Error())
+	}
+	return fmt.Errorf("failed to create storageclass: %s", err.Error())
+}
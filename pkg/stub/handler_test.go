@@ -0,0 +1,360 @@
+package stub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// useConfig sets the configuration of a test, the built-in one with the local provider adjusted by configure, and
+// returns a function restoring the built-in one
+func useConfig(configure func(operatorConfig *config.Config)) func() {
+	operatorConfig := config.Default()
+	operatorConfig.Provider = "local"
+	if configure != nil {
+		configure(operatorConfig)
+	}
+	config.Set(operatorConfig)
+	return func() { config.Set(config.Default()) }
+}
+
+// pendingClaim returns a pending PVC of the namespace requesting the StorageClass with the access mode
+func pendingClaim(namespace, name, className string, accessMode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &className,
+			AccessModes:      []v1.PersistentVolumeAccessMode{accessMode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+		Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending},
+	}
+}
+
+// getStorageClass returns the StorageClass with the name, nil if there is none
+func getStorageClass(handler *Handler, name string) *storagev1.StorageClass {
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	if err := handler.client.Get(storageClass); err != nil {
+		return nil
+	}
+	return storageClass
+}
+
+func TestReconcileCreatesStorageClass(t *testing.T) {
+	defer useConfig(nil)()
+	pvc := pendingClaim("default", "data", "local-rwo", v1.ReadWriteOnce)
+	handler := NewHandler(fake.NewClient(pvc))
+
+	pending, err := handler.reconcile("default/data")
+	if err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+	if !pending {
+		t.Error("the PVC is not pending until its volume is bound")
+	}
+	storageClass := getStorageClass(handler, "local-rwo")
+	if storageClass == nil {
+		t.Fatal("StorageClass local-rwo was not created")
+	}
+	if storageClass.Provisioner != "rancher.io/local-path" {
+		t.Errorf("StorageClass has provisioner %s instead of rancher.io/local-path", storageClass.Provisioner)
+	}
+	if !providers.IsManagedStorageClass(storageClass) {
+		t.Error("StorageClass is not labeled as managed")
+	}
+}
+
+func TestReconcileLeavesExistingStorageClass(t *testing.T) {
+	defer useConfig(nil)()
+	existing := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "standard",
+		},
+		Provisioner: "example.com/other",
+	}
+	handler := NewHandler(fake.NewClient(existing, pendingClaim("default", "data", "standard", v1.ReadWriteOnce)))
+
+	if _, err := handler.reconcile("default/data"); err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+	storageClass := getStorageClass(handler, "standard")
+	if storageClass.Provisioner != "example.com/other" || providers.IsManagedStorageClass(storageClass) {
+		t.Errorf("existing StorageClass was changed: %+v", storageClass)
+	}
+}
+
+func TestReconcileRefusedByPolicy(t *testing.T) {
+	defer useConfig(nil)()
+	policy := &v1alpha1.ProvisioningPolicy{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ProvisioningPolicy",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nfs-only",
+		},
+		Spec: v1alpha1.ProvisioningPolicySpec{
+			Rules: []v1alpha1.ProvisioningRule{{
+				Namespaces:   []string{"default"},
+				StorageTypes: []v1alpha1.StorageType{v1alpha1.StorageTypeNfs},
+			}},
+		},
+	}
+	handler := NewHandler(fake.NewClient(policy, pendingClaim("default", "data", "local-rwo", v1.ReadWriteOnce)))
+
+	pending, err := handler.reconcile("default/data")
+	if err != nil || pending {
+		t.Fatalf("a refused PVC is not retried, got pending %t and error %v", pending, err)
+	}
+	if getStorageClass(handler, "local-rwo") != nil {
+		t.Error("StorageClass local-rwo was created despite the ProvisioningPolicy")
+	}
+	if !handler.refused["default/data"] {
+		t.Error("the refused PVC is not remembered for ProvisioningPolicy changes")
+	}
+
+	handler.Handle(sdk.Context{}, sdk.Event{Object: policy})
+	if len(handler.refused) != 0 || handler.queue.Len() != 1 {
+		t.Errorf("a ProvisioningPolicy change did not requeue the refused PVC, %d refused and %d queued", len(handler.refused), handler.queue.Len())
+	}
+}
+
+func TestReconcileStorageClassReleasesUnused(t *testing.T) {
+	defer useConfig(func(operatorConfig *config.Config) {
+		operatorConfig.StorageClassGCPolicy = config.GCPolicyDelete
+	})()
+	pvc := pendingClaim("default", "data", "local-rwo", v1.ReadWriteOnce)
+	handler := NewHandler(fake.NewClient(pvc))
+	if _, err := handler.reconcile("default/data"); err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+
+	if _, err := handler.reconcileStorageClass("local-rwo"); err != nil {
+		t.Fatalf("reconcileStorageClass failed: %s", err.Error())
+	}
+	if getStorageClass(handler, "local-rwo") == nil {
+		t.Fatal("StorageClass local-rwo was released while a PVC uses it")
+	}
+
+	if err := handler.client.Delete(pvc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.reconcileStorageClass("local-rwo"); err != nil {
+		t.Fatalf("reconcileStorageClass failed: %s", err.Error())
+	}
+	if getStorageClass(handler, "local-rwo") != nil {
+		t.Error("unused StorageClass local-rwo was not released")
+	}
+}
+
+func TestReconcileStorageClassGrowsExpandableDataClaim(t *testing.T) {
+	defer useConfig(nil)()
+	pvc := pendingClaim("default", "shared", "local-rwx", v1.ReadWriteMany)
+	handler := NewHandler(fake.NewClient(pvc))
+	if _, err := handler.reconcile("default/shared"); err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+	grown := pendingClaim("default", "shared-2", "local-rwx", v1.ReadWriteMany)
+	grown.Spec.Resources.Requests[v1.ResourceStorage] = resource.MustParse("5Gi")
+	if err := handler.client.Create(grown); err != nil {
+		t.Fatal(err)
+	}
+	dataClaim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "local-rwx-data",
+			Namespace: config.Get().Nfs.Namespace,
+		},
+	}
+
+	// without a default StorageClass allowing expansion the update would be rejected forever
+	for i := 0; i < 2; i++ {
+		if _, err := handler.reconcileStorageClass("local-rwx"); err != nil {
+			t.Fatalf("an unexpandable backing PVC is not retried, got %s", err.Error())
+		}
+	}
+	if !handler.expansionRefused[dataClaim.Namespace+"/"+dataClaim.Name] {
+		t.Error("the unexpandable backing PVC is not remembered")
+	}
+
+	allowVolumeExpansion := true
+	defaultClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "standard",
+			Annotations: map[string]string{providers.DefaultStorageClassAnnotation: "true"},
+		},
+		Provisioner:          "rancher.io/local-path",
+		AllowVolumeExpansion: &allowVolumeExpansion,
+	}
+	if err := handler.client.Create(defaultClass); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.reconcileStorageClass("local-rwx"); err != nil {
+		t.Fatalf("reconcileStorageClass failed: %s", err.Error())
+	}
+	if err := handler.client.Get(dataClaim); err != nil {
+		t.Fatal(err)
+	}
+	size := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+	if want := resource.MustParse("8Gi"); size.Cmp(want) != 0 {
+		t.Errorf("the backing PVC was grown to %s instead of %s", size.String(), want.String())
+	}
+	if len(handler.expansionRefused) != 0 {
+		t.Error("the expanded backing PVC is still remembered as unexpandable")
+	}
+}
+
+func TestReconcileRefusesTierWithoutClassSuffix(t *testing.T) {
+	defer useConfig(func(operatorConfig *config.Config) {
+		operatorConfig.Provider = "aws"
+	})()
+	pvc := pendingClaim("default", "data", "standard", v1.ReadWriteOnce)
+	pvc.Annotations = map[string]string{providers.TierAnnotation: providers.TierFast}
+	handler := NewHandler(fake.NewClient(pvc))
+
+	pending, err := handler.reconcile("default/data")
+	if err != nil || pending {
+		t.Fatalf("a claim lacking the tier in its class name is not retried, got pending %t and error %v", pending, err)
+	}
+	if getStorageClass(handler, "standard") != nil {
+		t.Error("StorageClass standard was created for tier fast, claims of other tiers would bind to it")
+	}
+}
+
+func TestReconcileRefusesEncryptionMismatch(t *testing.T) {
+	defer useConfig(nil)()
+	existing := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "gp2",
+		},
+		Provisioner: "kubernetes.io/aws-ebs",
+		Parameters:  map[string]string{"type": "gp2"},
+	}
+	pvc := pendingClaim("default", "secret", "gp2", v1.ReadWriteOnce)
+	pvc.Annotations = map[string]string{providers.EncryptedAnnotation: "true"}
+	handler := NewHandler(fake.NewClient(existing, pvc))
+
+	pending, err := handler.reconcile("default/secret")
+	if err != nil || pending {
+		t.Fatalf("a claim its existing StorageClass cannot encrypt is not retried, got pending %t and error %v", pending, err)
+	}
+}
+
+func TestReconcileNfsConsumerInOtherNamespace(t *testing.T) {
+	defer useConfig(nil)()
+	policy := &v1alpha1.ProvisioningPolicy{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ProvisioningPolicy",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nfs-in-default",
+		},
+		Spec: v1alpha1.ProvisioningPolicySpec{
+			Rules: []v1alpha1.ProvisioningRule{{
+				Namespaces:   []string{"default"},
+				StorageTypes: []v1alpha1.StorageType{v1alpha1.StorageTypeNfs},
+			}},
+		},
+	}
+	handler := NewHandler(fake.NewClient(
+		policy,
+		pendingClaim("default", "shared", "shared-nfs", v1.ReadWriteMany),
+		pendingClaim("team", "shared", "shared-nfs", v1.ReadWriteMany),
+	))
+	if _, err := handler.reconcile("default/shared"); err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+	if getStorageClass(handler, "shared-nfs") == nil {
+		t.Fatal("StorageClass shared-nfs was not created")
+	}
+
+	// the Nfs stack lives in the Nfs namespace, consuming it from another namespace creates nothing
+	pending, err := handler.reconcile("team/shared")
+	if err != nil {
+		t.Fatalf("reconcile failed: %s", err.Error())
+	}
+	if !pending || handler.refused["team/shared"] {
+		t.Errorf("the consumer of the existing Nfs stack was refused, got pending %t", pending)
+	}
+}
+
+func TestReconcileRefusesTierOfProvisionerWithoutTiers(t *testing.T) {
+	// the metadata server knows nothing, the region of the cluster stays unknown
+	metadataServer := httptest.NewServer(http.NotFoundHandler())
+	defer metadataServer.Close()
+	defer useConfig(func(operatorConfig *config.Config) {
+		operatorConfig.Provider = "aws"
+		operatorConfig.Endpoints.Metadata = metadataServer.URL
+	})()
+	profile := &v1alpha1.StorageProfile{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageProfile",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "efs",
+		},
+		Spec: v1alpha1.StorageProfileSpec{
+			ClassNamePattern: "shared-.*",
+			Provisioners: []v1alpha1.ProvisionerSpec{{
+				Provider:    "aws",
+				AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				Provisioner: "efs.csi.aws.com",
+			}},
+		},
+	}
+	pvc := pendingClaim("default", "data", "shared-fast", v1.ReadWriteOnce)
+	pvc.Annotations = map[string]string{providers.TierAnnotation: providers.TierFast}
+	handler := NewHandler(fake.NewClient(profile, pvc))
+
+	pending, err := handler.reconcile("default/data")
+	if err != nil || pending {
+		t.Fatalf("a claim requesting a tier its provisioner does not have is not retried, got pending %t and error %v", pending, err)
+	}
+	if getStorageClass(handler, "shared-fast") != nil {
+		t.Error("StorageClass shared-fast was created without the requested tier")
+	}
+}
@@ -0,0 +1,139 @@
+package stub
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// checkProvisioningPolicy checks if the namespace of the PVC may trigger the storage type, if not it
+// tells why. Without any ProvisioningPolicy in the cluster everything is allowed.
+func (h *Handler) checkProvisioningPolicy(pvc *v1.PersistentVolumeClaim, storageType v1alpha1.StorageType) (bool, string, error) {
+	policyList := &v1alpha1.ProvisioningPolicyList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ProvisioningPolicy",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+	}
+	if err := h.client.List(metav1.NamespaceAll, policyList, ""); err != nil {
+		return false, "", fmt.Errorf("could not list ProvisioningPolicies: %s", err.Error())
+	}
+	if len(policyList.Items) == 0 {
+		return true, "", nil
+	}
+
+	var namespace *v1.Namespace
+	reason := fmt.Sprintf("no ProvisioningPolicy allows namespace %s to create %s", pvc.Namespace, storageType)
+	for _, policy := range policyList.Items {
+		for _, rule := range policy.Spec.Rules {
+			if !allowsStorageType(rule, storageType) {
+				continue
+			}
+			if rule.NamespaceSelector != nil && namespace == nil {
+				namespace = &v1.Namespace{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       "Namespace",
+						APIVersion: "v1",
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Name: pvc.Namespace,
+					},
+				}
+				if err := h.client.Get(namespace); err != nil {
+					return false, "", err
+				}
+			}
+			selected, err := selectsNamespace(rule, pvc.Namespace, namespace)
+			if err != nil {
+				return false, "", fmt.Errorf("invalid namespace selector in ProvisioningPolicy %s: %s", policy.Name, err.Error())
+			}
+			if !selected {
+				continue
+			}
+			withinLimits, limitReason, err := h.checkLimits(rule, pvc, storageType)
+			if err != nil {
+				return false, "", err
+			}
+			if withinLimits {
+				return true, "", nil
+			}
+			reason = fmt.Sprintf("ProvisioningPolicy %s: %s", policy.Name, limitReason)
+		}
+	}
+	return false, reason, nil
+}
+
+// allowsStorageType checks if the rule lists the storage type
+func allowsStorageType(rule v1alpha1.ProvisioningRule, storageType v1alpha1.StorageType) bool {
+	for _, allowed := range rule.StorageTypes {
+		if allowed == storageType {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsNamespace checks if the rule applies to the namespace by name or by labels
+func selectsNamespace(rule v1alpha1.ProvisioningRule, name string, namespace *v1.Namespace) (bool, error) {
+	for _, ruleNamespace := range rule.Namespaces {
+		if ruleNamespace == name {
+			return true, nil
+		}
+	}
+	if rule.NamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// checkLimits checks if creating one more StorageClass of the storage type for the PVC stays within the
+// count and size caps of the rule
+func (h *Handler) checkLimits(rule v1alpha1.ProvisioningRule, pvc *v1.PersistentVolumeClaim, storageType v1alpha1.StorageType) (bool, string, error) {
+	if rule.MaxCount == nil && rule.MaxTotalSize == nil {
+		return true, "", nil
+	}
+	storageClasses, err := providers.ListRequestedStorageClasses(h.client, pvc.Namespace, storageType)
+	if err != nil {
+		return false, "", err
+	}
+	if rule.MaxCount != nil && int32(len(storageClasses)) >= *rule.MaxCount {
+		return false, fmt.Sprintf("namespace %s already has %d of at most %d %s", pvc.Namespace, len(storageClasses), *rule.MaxCount, storageType), nil
+	}
+	if rule.MaxTotalSize == nil {
+		return true, "", nil
+	}
+	classNames := map[string]bool{}
+	for _, storageClass := range storageClasses {
+		classNames[storageClass.Name] = true
+	}
+	pvcList := &v1.PersistentVolumeClaimList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+	}
+	if err := h.client.List(pvc.Namespace, pvcList, ""); err != nil {
+		return false, "", err
+	}
+	total := resource.Quantity{}
+	total.Add(pvc.Spec.Resources.Requests[v1.ResourceStorage])
+	for _, claim := range pvcList.Items {
+		if claim.Name == pvc.Name || claim.Spec.StorageClassName == nil || !classNames[*claim.Spec.StorageClassName] {
+			continue
+		}
+		total.Add(claim.Spec.Resources.Requests[v1.ResourceStorage])
+	}
+	if total.Cmp(*rule.MaxTotalSize) > 0 {
+		return false, fmt.Sprintf("namespace %s would request %s of at most %s on %s", pvc.Namespace, total.String(), rule.MaxTotalSize.String(), storageType), nil
+	}
+	return true, "", nil
+}
@@ -1,78 +1,88 @@
 package providers
 
 import (
-	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
-	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
-	storagev1 "k8s.io/api/storage/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // AwsProvider holds info about Aws provider and allows us to implement the common interface
 type AwsProvider struct {
+	region string
+}
+
+// Name returns the name of the provider
+func (aws *AwsProvider) Name() string {
+	return "aws"
 }
 
-// CreateStorageClass creates a StorageClass based on specs described on PVC
-func (aws *AwsProvider) CreateStorageClass(pvc *v1.PersistentVolumeClaim) error {
-	logrus.Info("Creating new storage class")
-	provisioner, err := aws.determineProvisioner(pvc)
+// DesiredStorageClass builds the StorageClass based on specs described on PVC and the matching profile
+func (aws *AwsProvider) DesiredStorageClass(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*DesiredState, error) {
+	logrus.Info("Building new storage class")
+	provisioner, err := aws.DetermineProvisioner(pvc, profile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logrus.Info("Determining provisioner succeeded")
-	parameter, err := aws.determineParameters(pvc)
+	parameter, err := aws.DetermineParameters(pvc, provisioner)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logrus.Info("Determining parameter succeeded")
-	return sdk.Create(&storagev1.StorageClass{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "StorageClass",
-			APIVersion: "storage.k8s.io/v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            *pvc.Spec.StorageClassName,
-			Annotations:     nil,
-			OwnerReferences: nil,
-		},
-		Provisioner:  provisioner,
-		MountOptions: nil,
-		Parameters:   parameter,
-	})
+	return StorageClassState(aws.Name(), pvc, provisioner, parameter), nil
 }
 
-// GenerateMetadata generates metadata which are needed to create a StorageClass
+// GenerateMetadata generates metadata which are needed to create a StorageClass, the region is only used to check
+// KMS keys so it is not required
 func (aws *AwsProvider) GenerateMetadata() error {
+	region, err := metadataClient().AWSRegion()
+	if err != nil {
+		logrus.Warnf("Could not get the region, KMS keys are not checked against it: %s", err.Error())
+		return nil
+	}
+	aws.region = region
 	return nil
 }
 
-// determineParameters determines the access mode from PVC
-func (aws *AwsProvider) determineParameters(pvc *v1.PersistentVolumeClaim) (map[string]string, error) {
-	//var parameter = map[string]string{}
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce":
-			return nil, nil
+// DetermineParameters determines the StorageClass parameters of the chosen provisioner, the tier and the encryption
+// of the PVC. KMS keys have to be in the region of the cluster.
+func (aws *AwsProvider) DetermineParameters(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) (map[string]string, error) {
+	parameter, err := ApplyTier(pvc, provisioner, CopyParameters(provisioner))
+	if err != nil {
+		return nil, err
+	}
+	parameter, err = ApplyEncryption(pvc, provisioner, parameter)
+	if err != nil {
+		return nil, err
+	}
+	if key := parameter[awsEncryption.key]; key != "" {
+		// the key may come from the parameters of a profile, which are not checked by ApplyEncryption
+		keyRegion, err := kmsKeyRegion(key)
+		if err != nil {
+			return nil, err
+		}
+		if aws.region != "" && keyRegion != aws.region {
+			return nil, &EncryptionKeyError{Key: key, Reason: fmt.Sprintf("the key is in region %s, the cluster in %s", keyRegion, aws.region)}
 		}
 	}
-	return nil, errors.New("could not determine parameters")
+	return parameter, nil
 }
 
-// determineProvisioner determines what kind of provisioner should the storage class use
-func (aws *AwsProvider) determineProvisioner(pvc *v1.PersistentVolumeClaim) (string, error) {
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce":
-			return "kubernetes.io/aws-ebs", nil
-		case "ReadWriteMany":
-			return "", errors.New("Not supported yet")
-		case "ReadOnlyMany":
-			return "", errors.New("Not supported yet")
-		}
+// kmsKeyRegion returns the region of the KMS key ARN
+func kmsKeyRegion(key string) (string, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) < 6 || parts[0] != "arn" || parts[3] == "" {
+		return "", &EncryptionKeyError{Key: key, Reason: fmt.Sprintf("the key is not an ARN like %s", awsEncryption.keyExample)}
 	}
-	return "", errors.New("AccessMode is missing from the PVC")
+	return parts[3], nil
+}
+
+// DetermineProvisioner determines what kind of provisioner should the storage class use
+func (aws *AwsProvider) DetermineProvisioner(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error) {
+	return ResolveProvisioner(aws.Name(), pvc, profile)
 }
 
 // CheckBucketExistence checks if the bucket already exists
@@ -80,7 +90,7 @@ func (aws *AwsProvider) CheckBucketExistence(store *v1alpha1.ObjectStore) (bool,
 	return false, nil
 }
 
-// CreateObjectStoreBucket creates a bucket in a cloud specific object store
-func (aws *AwsProvider) CreateObjectStoreBucket(store *v1alpha1.ObjectStore) error {
-	return nil
+// DesiredBucket builds the bucket in a cloud specific object store, the provider creates none
+func (aws *AwsProvider) DesiredBucket(store *v1alpha1.ObjectStore) (*DesiredState, error) {
+	return &DesiredState{Provider: aws.Name()}, nil
 }
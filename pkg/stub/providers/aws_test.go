@@ -0,0 +1,38 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+func TestAwsDetermineParametersChecksKey(t *testing.T) {
+	aws := &AwsProvider{region: "eu-west-1"}
+	tests := []struct {
+		key    string
+		failed bool
+	}{
+		{key: awsKey, failed: false},
+		{key: "1234abcd-12ab-34cd-56ef-1234567890ab", failed: true},
+		{key: "alias/ebs", failed: true},
+		{key: "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab", failed: true},
+	}
+	for _, test := range tests {
+		// keys set in the parameters of a profile skip the format check of ApplyEncryption
+		provisioner := &v1alpha1.ProvisionerSpec{
+			Provisioner: awsEbsProvisioner,
+			Parameters:  map[string]string{"encrypted": "true", "kmsKeyId": test.key},
+		}
+		_, err := aws.DetermineParameters(claim("secret", v1.ReadWriteOnce), provisioner)
+		if !test.failed {
+			if err != nil {
+				t.Errorf("key %s: %s", test.key, err.Error())
+			}
+			continue
+		}
+		if _, ok := err.(*EncryptionKeyError); !ok {
+			t.Errorf("key %s: got error %v instead of an EncryptionKeyError", test.key, err)
+		}
+	}
+}
@@ -2,20 +2,24 @@ package providers
 
 import (
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/services/storage/mgmt/2017-10-01/storage"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/Azure/go-autorest/autorest/to"
 	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
-	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
 	"k8s.io/api/core/v1"
-	storagev1 "k8s.io/api/storage/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"math/rand"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // Metadata holds info about Azure
@@ -37,76 +41,87 @@ const (
 	kind           = "kind"
 )
 
-// CreateStorageClass creates a StorageClass based on specs described on PVC
-func (az *AzureProvider) CreateStorageClass(pvc *v1.PersistentVolumeClaim) error {
-	logrus.Info("Creating new storage class")
-	provisioner, err := az.determineProvisioner(pvc)
+// Name returns the name of the provider
+func (az *AzureProvider) Name() string {
+	return "azure"
+}
+
+// DesiredStorageClass builds the StorageClass based on specs described on PVC and the matching profile, AzureFile
+// classes without a storage account in the profile get their own one, which is reused if it exists already
+func (az *AzureProvider) DesiredStorageClass(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*DesiredState, error) {
+	logrus.Info("Building new storage class")
+	provisioner, err := az.DetermineProvisioner(pvc, profile)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	logrus.Info("Determining provisioner succeeded")
-	parameter, err := az.determineParameters(pvc)
+	parameter, err := az.DetermineParameters(pvc, provisioner)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	logrus.Info("Determining parameter succeeded")
-	if parameter[storageAccount] != "" {
-		createStorageAccount(context.TODO(), parameter[storageAccount], az)
-	}
-	return sdk.Create(&storagev1.StorageClass{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "StorageClass",
-			APIVersion: "storage.k8s.io/v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            *pvc.Spec.StorageClassName,
-			Annotations:     nil,
-			OwnerReferences: nil,
-		},
-		Provisioner:  provisioner,
-		MountOptions: nil,
-		Parameters:   parameter,
-	})
+	state := StorageClassState(az.Name(), pvc, provisioner, parameter)
+	if accountName := parameter[storageAccount]; accountName != "" && provisioner.Parameters[storageAccount] == "" {
+		state.Actions = append(state.Actions, Action{
+			Description: fmt.Sprintf("create %s storage account %s in resource group %s", parameter[skuName], accountName, az.metadata.resourceGroupName),
+			Apply: func() error {
+				created, err := ensureStorageAccount(context.TODO(), accountName, storage.SkuName(parameter[skuName]), az)
+				if err != nil {
+					return err
+				}
+				if created {
+					events.Normal(pvc, events.StorageAccountCreated, "created storage account %s for StorageClass %s", accountName, *pvc.Spec.StorageClassName)
+				}
+				return nil
+			},
+		})
+	}
+	return state, nil
 }
 
 // GenerateMetadata generates metadata which are needed to create a StorageClass
 func (az *AzureProvider) GenerateMetadata() error {
 	logrus.Infof("Getting Metadata from service")
-	var metadatas = [3]string{
-		"location",
-		"subscriptionId",
-		"resourceGroupName",
-	}
-	var result = map[string]string{}
-	for _, metadata := range metadatas {
-		req, err := http.NewRequest("GET", fmt.Sprintf("http://169.254.169.254/metadata/instance/compute/%s?api-version=2017-12-01&format=text", metadata), nil)
-		if err != nil {
-			logrus.Errorf("Error during getting %s, %s", metadata, err.Error())
-			return err
-		}
-		req.Header.Add("Metadata", "true")
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			logrus.Errorf("Error during getting %s, %s", metadata, err.Error())
-			return err
-		}
-		readMetadata, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logrus.Errorf("Error during reading response %s", err.Error())
-			return err
-		}
-		result[metadata] = string(readMetadata)
+	var err error
+	if az.metadata.location, err = metadataClient().AzureLocation(); err != nil {
+		logrus.Errorf("Error during getting location, %s", err.Error())
+		return err
+	}
+	if az.metadata.subscriptionID, err = metadataClient().AzureSubscriptionID(); err != nil {
+		logrus.Errorf("Error during getting subscriptionId, %s", err.Error())
+		return err
+	}
+	if az.metadata.resourceGroupName, err = metadataClient().AzureResourceGroup(); err != nil {
+		logrus.Errorf("Error during getting resourceGroupName, %s", err.Error())
+		return err
 	}
-	az.metadata.location = result["location"]
-	az.metadata.subscriptionID = result["subscriptionId"]
-	az.metadata.resourceGroupName = result["resourceGroupName"]
-
 	return nil
 }
 
-// createStorageAccount creates an Azure storage account
-func createStorageAccount(ctx context.Context, accountName string, az *AzureProvider) (s storage.Account, err error) {
+// ensureStorageAccount creates the Azure storage account with the SKU unless it exists in the resource group already,
+// it tells if the account was created
+func ensureStorageAccount(ctx context.Context, accountName string, sku storage.SkuName, az *AzureProvider) (bool, error) {
 	storageAccountsClient, err := createStorageAccountClient(az.metadata.subscriptionID)
+	if err != nil {
+		return false, fmt.Errorf("cannot authenticate to create storage account: %v", err)
+	}
+	_, err = storageAccountsClient.GetProperties(ctx, az.metadata.resourceGroupName, accountName)
+	if err == nil {
+		logrus.Infof("Storage account %s exists already", accountName)
+		return false, nil
+	}
+	if detailedErr, ok := err.(autorest.DetailedError); !ok || detailedErr.StatusCode != http.StatusNotFound {
+		return false, fmt.Errorf("cannot check storage account %s: %v", accountName, err)
+	}
+	if _, err := createStorageAccount(ctx, storageAccountsClient, accountName, sku, az); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// createStorageAccount creates an Azure storage account
+func createStorageAccount(ctx context.Context, storageAccountsClient storage.AccountsClient, accountName string, sku storage.SkuName, az *AzureProvider) (s storage.Account, err error) {
+	defer metrics.ObserveSince(metrics.CloudAPIDuration, time.Now(), az.Name(), "create_storage_account")
 
 	result, err := storageAccountsClient.CheckNameAvailability(
 		ctx,
@@ -115,10 +130,10 @@ func createStorageAccount(ctx context.Context, accountName string, az *AzureProv
 			Type: to.StringPtr("Microsoft.Storage/storageAccounts"),
 		})
 	if err != nil {
-		logrus.Fatalf("%s: %v", "storage account creation failed", err)
+		return s, fmt.Errorf("storage account creation failed: %v", err)
 	}
 	if *result.NameAvailable != true {
-		logrus.Fatalf("%s [%s]: %v: %v", "storage account name not available", accountName, err, *result.Message)
+		return s, fmt.Errorf("storage account name not available [%s]: %v", accountName, *result.Message)
 	}
 
 
@@ -128,8 +143,8 @@ func createStorageAccount(ctx context.Context, accountName string, az *AzureProv
 		accountName,
 		storage.AccountCreateParameters{
 			Sku: &storage.Sku{
-				Name: storage.StandardLRS},
-			Kind:     storage.Storage,
+				Name: sku},
+			Kind:     storageAccountKind(sku),
 			Location: to.StringPtr(az.metadata.location),
 			AccountPropertiesCreateParameters: &storage.AccountPropertiesCreateParameters{},
 			Tags: map[string]*string{"created-by": to.StringPtr("pvc-operator")},
@@ -149,9 +164,13 @@ func createStorageAccount(ctx context.Context, accountName string, az *AzureProv
 
 // createStorageAccountClient creates a client to communicate with Azure
 func createStorageAccountClient(subscriptionID string) (storage.AccountsClient, error) {
+	endpoints := config.Get().Endpoints
 	accountClient := storage.NewAccountsClient(subscriptionID)
+	if endpoints.AzureResourceManager != "" {
+		accountClient = storage.NewAccountsClientWithBaseURI(endpoints.AzureResourceManager, subscriptionID)
+	}
 	logrus.Info("Authenticating...")
-	authorizer, err := auth.NewMSIConfig().Authorizer()
+	authorizer, err := msiAuthorizer(endpoints.Metadata)
 	if err != nil {
 		logrus.Errorf("Error happened during authentication %s", err.Error())
 		return storage.AccountsClient{}, err
@@ -161,39 +180,49 @@ func createStorageAccountClient(subscriptionID string) (storage.AccountsClient,
 	return accountClient, nil
 }
 
-// determineParameters determines the access mode from PVC
-func (az *AzureProvider) determineParameters(pvc *v1.PersistentVolumeClaim) (map[string]string, error) {
-	var parameter = map[string]string{}
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce":
-			parameter[skuName] = "Standard_LRS"
-			parameter[kind] = "managed"
-			return parameter, nil
-		case "ReadWriteMany", "ReadOnlyMany":
-			loc := az.metadata.location
-			parameter[location] = loc
-			parameter[storageAccount] = generateStorageAccountName()
-			parameter[skuName] = "Standard_LRS"
-			return parameter, nil
-		}
+// msiAuthorizer authorizes with the managed identity of the VM, the token is requested from the metadata server
+func msiAuthorizer(metadataEndpoint string) (autorest.Authorizer, error) {
+	if metadataEndpoint == "" {
+		return auth.NewMSIConfig().Authorizer()
+	}
+	msiEndpoint := strings.TrimSuffix(metadataEndpoint, "/") + "/metadata/identity/oauth2/token"
+	token, err := adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azure.PublicCloud.ResourceManagerEndpoint)
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("could not determine parameters")
+	return autorest.NewBearerAuthorizer(token), nil
 }
 
-// determineProvisioner determines what kind of provisioner should the storage class use
-func (az *AzureProvider) determineProvisioner(pvc *v1.PersistentVolumeClaim) (string, error) {
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce":
-			return "kubernetes.io/azure-disk", nil
-		case "ReadWriteMany":
-			return "kubernetes.io/azure-file", nil
-		case "ReadOnlyMany":
-			return "kubernetes.io/azure-file", nil
+// DetermineParameters determines the StorageClass parameters of the chosen provisioner, the tier and the encryption
+// of the PVC, AzureFile classes get their own storage account unless the profile names one
+func (az *AzureProvider) DetermineParameters(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) (map[string]string, error) {
+	parameter, err := ApplyTier(pvc, provisioner, CopyParameters(provisioner))
+	if err != nil {
+		return nil, err
+	}
+	parameter, err = ApplyEncryption(pvc, provisioner, parameter)
+	if err != nil {
+		return nil, err
+	}
+	if provisioner.Provisioner != azureFileProvisioner {
+		return parameter, nil
+	}
+	if parameter == nil {
+		parameter = map[string]string{}
+	}
+	if parameter[storageAccount] == "" {
+		parameter[location] = az.metadata.location
+		parameter[storageAccount] = storageAccountName(az.metadata.subscriptionID, az.metadata.resourceGroupName, *pvc.Spec.StorageClassName)
+		if parameter[skuName] == "" {
+			parameter[skuName] = string(storage.StandardLRS)
 		}
 	}
-	return "", errors.New("AccessMode is missing from the PVC")
+	return parameter, nil
+}
+
+// DetermineProvisioner determines what kind of provisioner should the storage class use
+func (az *AzureProvider) DetermineProvisioner(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error) {
+	return ResolveProvisioner(az.Name(), pvc, profile)
 }
 
 // CheckBucketExistence checks if the bucket already exists
@@ -201,19 +230,24 @@ func (az *AzureProvider) CheckBucketExistence(bucket *v1alpha1.ObjectStore) (boo
 	return false, nil
 }
 
-// CreateObjectStoreBucket creates a bucket in a cloud specific object store
-func (az *AzureProvider) CreateObjectStoreBucket(*v1alpha1.ObjectStore) error {
-	return nil
+// DesiredBucket builds the bucket in a cloud specific object store, the provider creates none
+func (az *AzureProvider) DesiredBucket(*v1alpha1.ObjectStore) (*DesiredState, error) {
+	return &DesiredState{Provider: az.Name()}, nil
 }
 
-// generateStorageAccountName generates a random name of 24 chars length that consists of lower case letters and numbers
-func generateStorageAccountName() string {
-	letters := "abcdefghijklmnopqrstuvwxyz0123456789"
-	b := make([]byte, 24)
+// storageAccountName derives the storage account name of the StorageClass from the subscription, the resource group and
+// the class name, so the account of a class is found again instead of created twice. Names are 3-24 lower case letters
+// and numbers.
+func storageAccountName(subscriptionID, resourceGroup, className string) string {
+	hash := sha256.Sum256([]byte(subscriptionID + "/" + resourceGroup + "/" + className))
+	return "pvc" + hex.EncodeToString(hash[:])[:21]
+}
 
-	for i := range b {
-		b[i] = letters[rand.Int63() % int64(len(letters))]
+// storageAccountKind returns the kind of storage account holding file shares of the SKU, premium shares need a
+// FileStorage account which the storage API version in use has no constant for
+func storageAccountKind(sku storage.SkuName) storage.Kind {
+	if strings.HasPrefix(string(sku), "Premium_") {
+		return storage.Kind("FileStorage")
 	}
-
-	return string(b)
-}
\ No newline at end of file
+	return storage.Storage
+}
@@ -0,0 +1,54 @@
+package providers
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+func TestAzureDetermineParametersStorageAccount(t *testing.T) {
+	az := &AzureProvider{metadata: Metadata{location: "westeurope", subscriptionID: "subscription", resourceGroupName: "group"}}
+	validName := regexp.MustCompile("^[a-z0-9]{3,24}$")
+	tests := []struct {
+		className string
+		tier      string
+		sku       string
+	}{
+		{className: "shared", sku: "Standard_LRS"},
+		{className: "shared-fast", tier: TierFast, sku: "Premium_LRS"},
+		{className: "shared-cold", tier: TierCold, sku: "Standard_LRS"},
+	}
+	accounts := map[string]string{}
+	for _, test := range tests {
+		pvc := claim(test.className, v1.ReadWriteMany)
+		if test.tier != "" {
+			pvc.Annotations = map[string]string{TierAnnotation: test.tier}
+		}
+		provisioner := &v1alpha1.ProvisionerSpec{Provisioner: azureFileProvisioner}
+		parameters, err := az.DetermineParameters(pvc, provisioner)
+		if err != nil {
+			t.Fatalf("%s: %s", test.className, err.Error())
+		}
+		account := parameters[storageAccount]
+		if !validName.MatchString(account) {
+			t.Errorf("%s: invalid storage account name %q", test.className, account)
+		}
+		if other, ok := accounts[account]; ok {
+			t.Errorf("%s: got the storage account of %s", test.className, other)
+		}
+		accounts[account] = test.className
+		if parameters[skuName] != test.sku {
+			t.Errorf("%s: got skuName %s instead of %s", test.className, parameters[skuName], test.sku)
+		}
+
+		again, err := az.DetermineParameters(pvc, provisioner)
+		if err != nil {
+			t.Fatalf("%s: %s", test.className, err.Error())
+		}
+		if again[storageAccount] != account {
+			t.Errorf("%s: got storage account %s the second time instead of %s", test.className, again[storageAccount], account)
+		}
+	}
+}
@@ -3,62 +3,100 @@ package providers
 import (
 	"fmt"
 	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"net/http"
+	"k8s.io/apimachinery/pkg/labels"
 	"os"
 )
 
-// CommonProvider bonds together the required methods
+const (
+	// ManagedByLabel marks the objects created by the operator
+	ManagedByLabel = "banzaicloud.com/managed-by"
+	// ManagedByValue is the value of ManagedByLabel on objects created by the operator
+	ManagedByValue = "pvc-operator"
+	// RequesterNamespaceLabel holds the namespace of the PVC a StorageClass was created for
+	RequesterNamespaceLabel = "banzaicloud.com/requester-namespace"
+	// StorageTypeLabel holds the storage type of a StorageClass created by the operator
+	StorageTypeLabel = "banzaicloud.com/storage-type"
+	// StorageClassFinalizer keeps a managed StorageClass around while PVCs still use it
+	StorageClassFinalizer = "banzaicloud.com/storageclass-protection"
+	// DefaultStorageClassAnnotation marks the default StorageClass of the cluster
+	DefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	// BetaDefaultStorageClassAnnotation is the beta form of DefaultStorageClassAnnotation which is still honored
+	BetaDefaultStorageClassAnnotation = "storageclass.beta.kubernetes.io/is-default-class"
+)
+
+// CommonProvider bonds together the required methods, implementations are added with Register
 type CommonProvider interface {
-	CreateStorageClass(*v1.PersistentVolumeClaim) error
+	// Name returns the name the provider is registered with
+	Name() string
+	// DesiredStorageClass builds what serves the PVC with the matching profile without creating anything
+	DesiredStorageClass(*v1.PersistentVolumeClaim, *v1alpha1.StorageProfile) (*DesiredState, error)
+	// GenerateMetadata collects what the provider needs to create StorageClasses
 	GenerateMetadata() error
-	determineParameters(*v1.PersistentVolumeClaim) (map[string]string, error)
-	determineProvisioner(*v1.PersistentVolumeClaim) (string, error)
-	CreateObjectStoreBucket(*v1alpha1.ObjectStore) error
+	// DetermineParameters returns the StorageClass parameters of the chosen provisioner
+	DetermineParameters(*v1.PersistentVolumeClaim, *v1alpha1.ProvisionerSpec) (map[string]string, error)
+	// DetermineProvisioner chooses the provisioner of the profile serving the PVC
+	DetermineProvisioner(*v1.PersistentVolumeClaim, *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error)
+	// DesiredBucket builds what creates the bucket of the ObjectStore without creating anything
+	DesiredBucket(*v1alpha1.ObjectStore) (*DesiredState, error)
+	// CheckBucketExistence checks if the bucket of the ObjectStore exists
 	CheckBucketExistence(*v1alpha1.ObjectStore) (bool, error)
 }
 
-// DetermineProvider determines the cloud provider type based on metadata server
-func DetermineProvider() (CommonProvider, error) {
-	var providers = map[string]string{
-		"azure":  "http://169.254.169.254/metadata/instance?api-version=2017-12-01",
-		"aws":    "http://169.254.169.254/latest/meta-data/",
-		"google": "http://169.254.169.254/0.1/meta-data/",
+// UnsupportedAccessModeError is returned when the provider cannot serve the access mode of a PVC
+type UnsupportedAccessModeError struct {
+	AccessMode v1.PersistentVolumeAccessMode
+	Provider   string
+}
+
+func (e *UnsupportedAccessModeError) Error() string {
+	return fmt.Sprintf("access mode %s not supported on %s", e.AccessMode, e.Provider)
+}
+
+// StorageClassState returns the StorageClass of the PVC rendered from the chosen provisioner, providers call it from
+// DesiredStorageClass
+func StorageClassState(provider string, pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec, parameters map[string]string) *DesiredState {
+	return &DesiredState{
+		Provider: provider,
+		Objects:  []sdk.Object{ManagedStorageClass(pvc, provisioner, parameters)},
 	}
-	for key, value := range providers {
-		req, err := http.NewRequest("GET", value, nil)
-		if err != nil {
-			logrus.Errorf("Could not create a proper http request %s", err.Error())
-			return nil, err
-		}
-		req.Header.Set("Metadata", "true")
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("Something happened during the request %s", err.Error())
-		}
-		if resp.StatusCode == 404 || resp.StatusCode == 405 {
-			continue
-		}
-		switch key {
-		case "azure":
-			return &AzureProvider{}, nil
-		case "aws":
-			return &AwsProvider{}, nil
-		case "google":
-			return &GoogleProvider{}, nil
-		}
+}
 
+// ManagedStorageClass renders the StorageClass of the PVC from the chosen provisioner
+func ManagedStorageClass(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec, parameters map[string]string) *storagev1.StorageClass {
+	labels := storageClassLabels(pvc, storageTypeOf(provisioner))
+	if tier := tierOf(pvc, provisioner); tier != "" {
+		labels[TierAnnotation] = tier
+	}
+	return &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       *pvc.Spec.StorageClassName,
+			Labels:     labels,
+			Finalizers: []string{StorageClassFinalizer},
+		},
+		Provisioner:          provisioner.Provisioner,
+		Parameters:           parameters,
+		ReclaimPolicy:        provisioner.ReclaimPolicy,
+		MountOptions:         provisioner.MountOptions,
+		VolumeBindingMode:    provisioner.VolumeBindingMode,
+		AllowVolumeExpansion: allowVolumeExpansion(provisioner),
 	}
-	return nil, fmt.Errorf("could not determine cloud provider")
 }
 
 // CheckPersistentVolumeClaimExistence checks if the PVC already exists
-func CheckPersistentVolumeClaimExistence(name, namespace string) bool {
+func CheckPersistentVolumeClaimExistence(apiClient client.Client, name, namespace string) bool {
 	persistentVolumeClaim := &v1.PersistentVolumeClaim{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "PersistentVolumeClaim",
@@ -69,7 +107,7 @@ func CheckPersistentVolumeClaimExistence(name, namespace string) bool {
 			Namespace: namespace,
 		},
 	}
-	if err := sdk.Get(persistentVolumeClaim); err != nil {
+	if err := apiClient.Get(persistentVolumeClaim); err != nil {
 		logrus.Infof("PersistentVolumeClaim does not exists %s", err.Error())
 		return false
 	}
@@ -78,7 +116,7 @@ func CheckPersistentVolumeClaimExistence(name, namespace string) bool {
 }
 
 // CheckStorageClassExistence checks if the storage class already exists
-func CheckStorageClassExistence(name string) bool {
+func CheckStorageClassExistence(apiClient client.Client, name string) bool {
 	storageClass := &storagev1.StorageClass{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "StorageClass",
@@ -88,7 +126,7 @@ func CheckStorageClassExistence(name string) bool {
 			Name: name,
 		},
 	}
-	if err := sdk.Get(storageClass); err != nil {
+	if err := apiClient.Get(storageClass); err != nil {
 		logrus.Infof("Storageclass does not exist %s", err.Error())
 		return false
 	}
@@ -96,6 +134,20 @@ func CheckStorageClassExistence(name string) bool {
 	return true
 }
 
+// DefaultStorageClassName returns the name of the default StorageClass of the cluster, empty if there is none
+func DefaultStorageClassName(apiClient client.Client) (string, error) {
+	storageClasses, err := listStorageClasses(apiClient, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, storageClass := range storageClasses {
+		if storageClass.Annotations[DefaultStorageClassAnnotation] == "true" || storageClass.Annotations[BetaDefaultStorageClassAnnotation] == "true" {
+			return storageClass.Name, nil
+		}
+	}
+	return "", nil
+}
+
 // asOwner returns an OwnerReference set as the memcached CR
 func asOwner(m *v1beta1.Deployment) metav1.OwnerReference {
 	trueVar := true
@@ -109,7 +161,7 @@ func asOwner(m *v1beta1.Deployment) metav1.OwnerReference {
 }
 
 //getOwner returns the Deployment created for the Operator
-func getOwner() *v1beta1.Deployment {
+func getOwner(apiClient client.Client) *v1beta1.Deployment {
 	const operatorNamespace = "OPERATOR_NAMESPACE"
 
 	deployment := &v1beta1.Deployment{
@@ -118,13 +170,139 @@ func getOwner() *v1beta1.Deployment {
 			APIVersion: "extensions/v1beta1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      os.Getenv("OWNER_REFERENCE_NAME"),
+			Name:      config.Get().OwnerReferenceName,
 			Namespace: os.Getenv(operatorNamespace),
 		},
 	}
-	if err := sdk.Get(deployment); err != nil {
+	if err := apiClient.Get(deployment); err != nil {
 		logrus.Infof("PVC-handler does not exists! %s", err.Error())
 		return nil
 	}
 	return deployment
 }
+
+// managedLabels returns the labels put on every object created by the operator
+func managedLabels() map[string]string {
+	return map[string]string{ManagedByLabel: ManagedByValue}
+}
+
+// storageClassLabels returns the labels of a StorageClass created for the PVC
+func storageClassLabels(pvc *v1.PersistentVolumeClaim, storageType v1alpha1.StorageType) map[string]string {
+	set := managedLabels()
+	set[RequesterNamespaceLabel] = pvc.Namespace
+	set[StorageTypeLabel] = string(storageType)
+	return set
+}
+
+// storageTypeOf returns the storage type created with a StorageClass of the provisioner
+func storageTypeOf(provisioner *v1alpha1.ProvisionerSpec) v1alpha1.StorageType {
+	switch {
+	case provisioner.Provisioner == NfsProvisioner:
+		return v1alpha1.StorageTypeNfs
+	case provisioner.Provisioner == azureFileProvisioner && provisioner.Parameters[storageAccount] == "":
+		return v1alpha1.StorageTypeAzureStorageAccount
+	}
+	return v1alpha1.StorageTypeStorageClass
+}
+
+// StorageTypeFor returns the storage type the provider would create for the PVC
+func StorageTypeFor(provider CommonProvider, pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (v1alpha1.StorageType, error) {
+	if profile.Spec.Nfs {
+		return v1alpha1.StorageTypeNfs, nil
+	}
+	provisioner, err := provider.DetermineProvisioner(pvc, profile)
+	if err != nil {
+		return "", err
+	}
+	return storageTypeOf(provisioner), nil
+}
+
+// IsManagedStorageClass checks if the StorageClass was created by the operator
+func IsManagedStorageClass(storageClass *storagev1.StorageClass) bool {
+	return storageClass.Labels[ManagedByLabel] == ManagedByValue
+}
+
+// CountStorageClassConsumers counts the PVCs in all namespaces which use the given StorageClass
+// and are not being deleted
+func CountStorageClassConsumers(apiClient client.Client, name string) (int, error) {
+	consumers, err := storageClassConsumers(apiClient, name)
+	return len(consumers), err
+}
+
+// storageClassConsumers lists the PVCs in all namespaces which use the given StorageClass and are not being deleted
+func storageClassConsumers(apiClient client.Client, name string) ([]v1.PersistentVolumeClaim, error) {
+	pvcList := &v1.PersistentVolumeClaimList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+	}
+	if err := apiClient.List(metav1.NamespaceAll, pvcList, ""); err != nil {
+		return nil, err
+	}
+	var consumers []v1.PersistentVolumeClaim
+	for _, pvc := range pvcList.Items {
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != name {
+			continue
+		}
+		if pvc.DeletionTimestamp != nil {
+			continue
+		}
+		consumers = append(consumers, pvc)
+	}
+	return consumers, nil
+}
+
+// ListManagedStorageClasses lists the StorageClasses created by the operator
+func ListManagedStorageClasses(apiClient client.Client) ([]storagev1.StorageClass, error) {
+	return listStorageClasses(apiClient, managedLabels())
+}
+
+// ListRequestedStorageClasses lists the StorageClasses of the storage type created for PVCs of the namespace
+func ListRequestedStorageClasses(apiClient client.Client, namespace string, storageType v1alpha1.StorageType) ([]storagev1.StorageClass, error) {
+	set := managedLabels()
+	set[RequesterNamespaceLabel] = namespace
+	set[StorageTypeLabel] = string(storageType)
+	return listStorageClasses(apiClient, set)
+}
+
+// listStorageClasses lists the StorageClasses having all the given labels
+func listStorageClasses(apiClient client.Client, set map[string]string) ([]storagev1.StorageClass, error) {
+	storageClassList := &storagev1.StorageClassList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+	}
+	selector := labels.SelectorFromSet(set).String()
+	if err := apiClient.List(metav1.NamespaceAll, storageClassList, selector); err != nil {
+		return nil, err
+	}
+	return storageClassList.Items, nil
+}
+
+// ReleaseStorageClass removes the operator finalizer from the StorageClass and deletes it
+// unless its deletion is already in progress
+func ReleaseStorageClass(apiClient client.Client, storageClass *storagev1.StorageClass) error {
+	finalizers := make([]string, 0, len(storageClass.Finalizers))
+	for _, finalizer := range storageClass.Finalizers {
+		if finalizer != StorageClassFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	if len(finalizers) != len(storageClass.Finalizers) {
+		storageClass.Finalizers = finalizers
+		if err := apiClient.Update(storageClass); err != nil {
+			return err
+		}
+	}
+	if storageClass.DeletionTimestamp != nil {
+		return nil
+	}
+	logrus.Infof("Deleting StorageClass %s", storageClass.Name)
+	err := apiClient.Delete(storageClass)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers/metadata"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ResolveProvider returns the provider named by override, without override it is detected by trying the given
+// detection strategies in order
+func ResolveProvider(apiClient client.Client, override string, strategies []string) (CommonProvider, error) {
+	if override != "" {
+		logrus.Infof("Using provider %s from the configuration", override)
+		provider, err := NewProvider(apiClient, override)
+		if err == nil {
+			metrics.ProviderDetections.WithLabelValues(provider.Name(), "config").Inc()
+		}
+		return provider, err
+	}
+	return DetermineProvider(apiClient, strategies)
+}
+
+// DetermineProvider tries the detection strategies in order and returns the provider found by the first successful one.
+// Without the local strategy an error is returned if all of them fail, a slow metadata server must not pin the
+// operator to the local provider.
+func DetermineProvider(apiClient client.Client, strategies []string) (CommonProvider, error) {
+	var problems []string
+	for _, strategy := range strategies {
+		var name string
+		var err error
+		switch strategy {
+		case config.DetectionMetadata:
+			name, err = detectFromMetadata()
+		case config.DetectionNode:
+			name, err = detectFromNodes(apiClient)
+		case config.DetectionLocal:
+			name = "local"
+		default:
+			err = fmt.Errorf("unknown detection strategy")
+		}
+		if err != nil {
+			logrus.Infof("Provider detection using %s failed: %s", strategy, err.Error())
+			problems = append(problems, fmt.Sprintf("%s: %s", strategy, err.Error()))
+			continue
+		}
+		logrus.Infof("Provider detected as %s using %s", name, strategy)
+		metrics.ProviderDetections.WithLabelValues(name, strategy).Inc()
+		return NewProvider(apiClient, name)
+	}
+	return nil, fmt.Errorf("could not determine cloud provider: %s", strings.Join(problems, "; "))
+}
+
+// detectFromMetadata probes the metadata servers of all registered providers in parallel and returns the most
+// preferred provider whose endpoint answered
+func detectFromMetadata() (string, error) {
+	client := metadataClient()
+	candidates := registrations()
+	found := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func(i int, detector Detector) {
+			defer wg.Done()
+			found[i] = detector.ProbeMetadata(client)
+		}(i, candidate.detector)
+	}
+	wg.Wait()
+	for i, candidate := range candidates {
+		if found[i] {
+			return candidate.name, nil
+		}
+	}
+	return "", fmt.Errorf("no metadata server answered")
+}
+
+// detectFromNodes returns the most preferred registered provider matching one of the Nodes
+func detectFromNodes(apiClient client.Client) (string, error) {
+	nodes := &v1.NodeList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Node",
+			APIVersion: "v1",
+		},
+	}
+	if err := apiClient.List("", nodes, ""); err != nil {
+		return "", err
+	}
+	for _, candidate := range registrations() {
+		for i := range nodes.Items {
+			if candidate.detector.MatchesNode(&nodes.Items[i]) {
+				return candidate.name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("none of the %d Nodes matches a registered provider", len(nodes.Items))
+}
+
+// metadataClient returns the shared client of the configured metadata server
+func metadataClient() *metadata.Client {
+	return metadata.For(config.Get().Endpoints.Metadata)
+}
@@ -0,0 +1,117 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+)
+
+// useMetadataServer points the configuration to a metadata server answering like GCE if google is set, otherwise
+// everything is not found. The returned function stops it and restores the built-in configuration.
+func useMetadataServer(google bool) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !google || r.URL.Path != "/computeMetadata/v1/project/project-id" || r.Header.Get("Metadata-Flavor") != "Google" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		fmt.Fprint(w, "project")
+	}))
+	operatorConfig := config.Default()
+	operatorConfig.Endpoints.Metadata = server.URL
+	config.Set(operatorConfig)
+	return func() {
+		server.Close()
+		config.Set(config.Default())
+	}
+}
+
+func TestDetectFromNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    []sdk.Object
+		provider string
+	}{
+		{name: "azure providerID", nodes: []sdk.Object{node("vm", "azure:///subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/virtualMachines/vm", nil)}, provider: "azure"},
+		{name: "aws providerID", nodes: []sdk.Object{node("ip-10-0-0-1", "aws:///eu-west-1a/i-0123456789abcdef0", nil)}, provider: "aws"},
+		{name: "gce providerID", nodes: []sdk.Object{node("gke-node", "gce://project/europe-west1-b/gke-node", nil)}, provider: "google"},
+		{name: "kind providerID", nodes: []sdk.Object{node("kind-control-plane", "kind://docker/kind/kind-control-plane", nil)}, provider: "local"},
+		{name: "aks label", nodes: []sdk.Object{node("aks", "", map[string]string{"kubernetes.azure.com/cluster": "cluster"})}, provider: "azure"},
+		{name: "eks label", nodes: []sdk.Object{node("eks", "", map[string]string{"eks.amazonaws.com/nodegroup": "workers"})}, provider: "aws"},
+		{name: "gke label", nodes: []sdk.Object{node("gke", "", map[string]string{"cloud.google.com/gke-nodepool": "default-pool"})}, provider: "google"},
+		{name: "minikube label", nodes: []sdk.Object{node("minikube", "", map[string]string{"minikube.k8s.io/name": "minikube"})}, provider: "local"},
+		{name: "preferred provider wins", nodes: []sdk.Object{
+			node("gke", "gce://project/europe-west1-b/gke", nil),
+			node("ip-10-0-0-1", "aws:///eu-west-1a/i-0123456789abcdef0", nil),
+		}, provider: "aws"},
+		{name: "prefix only", nodes: []sdk.Object{node("node", "awsome://node", nil)}, provider: ""},
+		{name: "unknown", nodes: []sdk.Object{node("worker", "", map[string]string{"kubernetes.io/hostname": "worker"})}, provider: ""},
+		{name: "no nodes", provider: ""},
+	}
+	for _, test := range tests {
+		provider, err := detectFromNodes(fake.NewClient(test.nodes...))
+		if test.provider == "" {
+			if err == nil {
+				t.Errorf("%s: got provider %s instead of an error", test.name, provider)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		} else if provider != test.provider {
+			t.Errorf("%s: got provider %s instead of %s", test.name, provider, test.provider)
+		}
+	}
+}
+
+func TestDetermineProviderStrategyOrder(t *testing.T) {
+	awsNode := node("ip-10-0-0-1", "aws:///eu-west-1a/i-0123456789abcdef0", nil)
+	tests := []struct {
+		name       string
+		google     bool
+		strategies []string
+		provider   string
+	}{
+		{name: "metadata first", google: true, strategies: []string{config.DetectionMetadata, config.DetectionNode}, provider: "google"},
+		{name: "node first", google: true, strategies: []string{config.DetectionNode, config.DetectionMetadata}, provider: "aws"},
+		{name: "metadata fails", google: false, strategies: []string{config.DetectionMetadata, config.DetectionNode}, provider: "aws"},
+		{name: "unknown strategy skipped", google: true, strategies: []string{"guess", config.DetectionMetadata}, provider: "google"},
+		{name: "local last", google: false, strategies: []string{config.DetectionMetadata, config.DetectionLocal}, provider: "local"},
+		{name: "all fail", google: false, strategies: []string{config.DetectionMetadata}, provider: ""},
+	}
+	for _, test := range tests {
+		restore := useMetadataServer(test.google)
+		provider, err := DetermineProvider(fake.NewClient(awsNode), test.strategies)
+		restore()
+		if test.provider == "" {
+			if err == nil {
+				t.Errorf("%s: got provider %s instead of an error", test.name, provider.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+		} else if provider.Name() != test.provider {
+			t.Errorf("%s: got provider %s instead of %s", test.name, provider.Name(), test.provider)
+		}
+	}
+}
+
+func TestResolveProviderOverride(t *testing.T) {
+	apiClient := fake.NewClient(node("ip-10-0-0-1", "aws:///eu-west-1a/i-0123456789abcdef0", nil))
+	provider, err := ResolveProvider(apiClient, "azure", []string{config.DetectionNode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Name() != "azure" {
+		t.Errorf("got provider %s instead of the configured azure", provider.Name())
+	}
+	if _, err := ResolveProvider(apiClient, "openstack", []string{config.DetectionNode}); err == nil {
+		t.Error("expected an error for an unknown configured provider")
+	}
+}
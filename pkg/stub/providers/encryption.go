@@ -0,0 +1,182 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// EncryptedAnnotation requests encryption at rest with the default key of the provider for the volume of a PVC
+	EncryptedAnnotation = "banzaicloud.com/encrypted"
+	// EncryptionKeyAnnotation requests encryption at rest with the referenced key for the volume of a PVC
+	EncryptionKeyAnnotation = "banzaicloud.com/encryption-key"
+)
+
+// encryptionParameters tells how a provisioner is asked to encrypt volumes
+type encryptionParameters struct {
+	// encrypted is set to true on encrypted classes, empty if the volumes are always encrypted at rest
+	encrypted string
+	// key is set to the key reference, empty if the provisioner cannot use a customer managed key
+	key string
+	// keyProvisioner is the provisioner to use instead for a customer managed key
+	keyProvisioner string
+	// keyFormat is what a usable key reference looks like
+	keyFormat *regexp.Regexp
+	// keyExample is shown when a key reference does not match keyFormat
+	keyExample string
+}
+
+var (
+	awsEncryption = encryptionParameters{
+		encrypted:  "encrypted",
+		key:        "kmsKeyId",
+		keyFormat:  regexp.MustCompile(`^arn:aws[a-z-]*:kms:[a-z0-9-]+:[0-9]{12}:(key|alias)/[A-Za-z0-9/_-]+$`),
+		keyExample: "arn:aws:kms:<region>:<account>:key/<key-id>",
+	}
+	googleEncryption = encryptionParameters{
+		key:        "disk-encryption-kms-key",
+		keyFormat:  regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`),
+		keyExample: "projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>",
+	}
+	azureEncryption = encryptionParameters{
+		key:        "diskEncryptionSetID",
+		keyFormat:  regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/diskEncryptionSets/[^/]+$`),
+		keyExample: "/subscriptions/<subscription>/resourceGroups/<group>/providers/Microsoft.Compute/diskEncryptionSets/<set>",
+	}
+	// the in-tree GCE and Azure provisioners encrypt with a platform key only, the key parameters are CSI only
+	googleInTreeEncryption = encryptionParameters{keyProvisioner: gcePdCSIProvisioner}
+	azureInTreeEncryption  = encryptionParameters{keyProvisioner: azureDiskCSIProvisioner}
+)
+
+// encryptionByProvisioner lists the provisioners which can encrypt volumes
+var encryptionByProvisioner = map[string]encryptionParameters{
+	awsEbsProvisioner:       awsEncryption,
+	awsEbsCSIProvisioner:    awsEncryption,
+	gcePdProvisioner:        googleInTreeEncryption,
+	gcePdCSIProvisioner:     googleEncryption,
+	azureDiskProvisioner:    azureInTreeEncryption,
+	azureDiskCSIProvisioner: azureEncryption,
+}
+
+// EncryptionKeyError is returned when the volumes of a PVC cannot be encrypted as requested
+type EncryptionKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *EncryptionKeyError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("cannot encrypt volumes: %s", e.Reason)
+	}
+	return fmt.Sprintf("cannot encrypt volumes with key %s: %s", e.Key, e.Reason)
+}
+
+// ClaimEncryption returns the encryption requested for the PVC, nil if none. The annotations of the PVC can request
+// encryption or another key but cannot turn off the encryption of the profile.
+func ClaimEncryption(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) (*v1alpha1.EncryptionSpec, error) {
+	var encryption *v1alpha1.EncryptionSpec
+	if provisioner.Encryption != nil {
+		encryption = provisioner.Encryption.DeepCopy()
+	}
+	if value, ok := pvc.Annotations[EncryptedAnnotation]; ok {
+		encrypted, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, &EncryptionKeyError{Reason: fmt.Sprintf("annotation %s must be true or false, not %q", EncryptedAnnotation, value)}
+		}
+		if encrypted && encryption == nil {
+			encryption = &v1alpha1.EncryptionSpec{}
+		}
+	}
+	if key := strings.TrimSpace(pvc.Annotations[EncryptionKeyAnnotation]); key != "" {
+		if encryption == nil {
+			encryption = &v1alpha1.EncryptionSpec{}
+		}
+		encryption.Key = key
+	}
+	return encryption, nil
+}
+
+// ApplyEncryption sets the encryption parameters requested for the PVC after checking the format of the key
+// reference. Cloud providers call it from DetermineParameters.
+func ApplyEncryption(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec, parameters map[string]string) (map[string]string, error) {
+	encryption, err := ClaimEncryption(pvc, provisioner)
+	if err != nil || encryption == nil {
+		return parameters, err
+	}
+	supported, ok := encryptionByProvisioner[provisioner.Provisioner]
+	if !ok {
+		return nil, &EncryptionKeyError{Key: encryption.Key, Reason: fmt.Sprintf("provisioner %s does not support encryption", provisioner.Provisioner)}
+	}
+	if encryption.Key != "" && supported.key == "" {
+		return nil, &EncryptionKeyError{Key: encryption.Key, Reason: fmt.Sprintf("provisioner %s cannot use a customer managed key, use %s instead", provisioner.Provisioner, supported.keyProvisioner)}
+	}
+	if encryption.Key != "" && !supported.keyFormat.MatchString(encryption.Key) {
+		return nil, &EncryptionKeyError{Key: encryption.Key, Reason: fmt.Sprintf("provisioner %s expects a key like %s", provisioner.Provisioner, supported.keyExample)}
+	}
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	if supported.encrypted != "" {
+		parameters[supported.encrypted] = "true"
+	}
+	if encryption.Key != "" {
+		parameters[supported.key] = encryption.Key
+	}
+	return parameters, nil
+}
+
+// EncryptionMismatch returns why the existing StorageClass of the PVC does not encrypt its volumes as the annotations of
+// the PVC request and whether it does not. Classes of provisioners the operator cannot encrypt with are not checked.
+func EncryptionMismatch(apiClient client.Client, pvc *v1.PersistentVolumeClaim) (string, bool) {
+	if pvc.Spec.StorageClassName == nil {
+		return "", false
+	}
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: *pvc.Spec.StorageClassName,
+		},
+	}
+	if err := apiClient.Get(storageClass); err != nil {
+		return "", false
+	}
+	supported, ok := encryptionByProvisioner[storageClass.Provisioner]
+	if !ok {
+		return "", false
+	}
+	// only the annotations count, the encryption of the profile was applied when the class was created
+	encryption, err := ClaimEncryption(pvc, &v1alpha1.ProvisionerSpec{Provisioner: storageClass.Provisioner})
+	if err != nil {
+		return err.Error(), true
+	}
+	if encryption == nil {
+		return "", false
+	}
+	if supported.encrypted != "" && storageClass.Parameters[supported.encrypted] != "true" {
+		return fmt.Sprintf("StorageClass %s does not encrypt its volumes, request another StorageClass", storageClass.Name), true
+	}
+	if encryption.Key == "" {
+		return "", false
+	}
+	if supported.key == "" {
+		return fmt.Sprintf("StorageClass %s of provisioner %s cannot use key %s", storageClass.Name, storageClass.Provisioner, encryption.Key), true
+	}
+	if classKey := storageClass.Parameters[supported.key]; classKey != encryption.Key {
+		if classKey == "" {
+			return fmt.Sprintf("StorageClass %s encrypts with the default key, not with %s, request another StorageClass", storageClass.Name, encryption.Key), true
+		}
+		return fmt.Sprintf("StorageClass %s encrypts with key %s, not with %s, request another StorageClass", storageClass.Name, classKey, encryption.Key), true
+	}
+	return "", false
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	googleKey = "projects/p/locations/europe-west1/keyRings/r/cryptoKeys/k"
+	awsKey    = "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+)
+
+// encryptedClaim returns a PVC of the StorageClass requesting encryption with the key, the default key if it is empty
+func encryptedClaim(className, key string) *v1.PersistentVolumeClaim {
+	pvc := claim(className, v1.ReadWriteOnce)
+	pvc.Annotations = map[string]string{EncryptedAnnotation: "true"}
+	if key != "" {
+		pvc.Annotations[EncryptionKeyAnnotation] = key
+	}
+	return pvc
+}
+
+func TestApplyEncryption(t *testing.T) {
+	tests := []struct {
+		provisioner string
+		key         string
+		parameter   string
+		failed      bool
+	}{
+		{provisioner: gcePdProvisioner, key: "", parameter: "", failed: false},
+		{provisioner: gcePdProvisioner, key: googleKey, failed: true},
+		{provisioner: gcePdCSIProvisioner, key: googleKey, parameter: "disk-encryption-kms-key", failed: false},
+		{provisioner: azureDiskProvisioner, key: "/subscriptions/s/resourceGroups/g/providers/Microsoft.Compute/diskEncryptionSets/d", failed: true},
+		{provisioner: awsEbsProvisioner, key: awsKey, parameter: "kmsKeyId", failed: false},
+	}
+	for _, test := range tests {
+		provisioner := &v1alpha1.ProvisionerSpec{Provisioner: test.provisioner}
+		parameters, err := ApplyEncryption(encryptedClaim("secret", test.key), provisioner, nil)
+		if test.failed {
+			if _, ok := err.(*EncryptionKeyError); !ok {
+				t.Errorf("%s with key %q: got error %v instead of an EncryptionKeyError", test.provisioner, test.key, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s with key %q: %s", test.provisioner, test.key, err.Error())
+		}
+		if test.parameter != "" && parameters[test.parameter] != test.key {
+			t.Errorf("%s: got parameters %v instead of %s %s", test.provisioner, parameters, test.parameter, test.key)
+		}
+	}
+}
+
+func TestEncryptionMismatch(t *testing.T) {
+	storageClass := func(name string, parameters map[string]string) *storagev1.StorageClass {
+		return &storagev1.StorageClass{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "StorageClass",
+				APIVersion: "storage.k8s.io/v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Provisioner: awsEbsProvisioner,
+			Parameters:  parameters,
+		}
+	}
+	apiClient := fake.NewClient(
+		storageClass("plain", map[string]string{"type": "gp2"}),
+		storageClass("encrypted", map[string]string{"encrypted": "true"}),
+		storageClass("keyed", map[string]string{"encrypted": "true", "kmsKeyId": awsKey}),
+	)
+	otherKey := "arn:aws:kms:eu-west-1:123456789012:key/other"
+	tests := []struct {
+		pvc      *v1.PersistentVolumeClaim
+		mismatch bool
+	}{
+		{pvc: claim("plain", v1.ReadWriteOnce), mismatch: false},
+		{pvc: encryptedClaim("plain", ""), mismatch: true},
+		{pvc: encryptedClaim("encrypted", ""), mismatch: false},
+		{pvc: encryptedClaim("encrypted", awsKey), mismatch: true},
+		{pvc: encryptedClaim("keyed", awsKey), mismatch: false},
+		{pvc: encryptedClaim("keyed", otherKey), mismatch: true},
+		{pvc: encryptedClaim("missing", awsKey), mismatch: false},
+	}
+	for _, test := range tests {
+		reason, mismatch := EncryptionMismatch(apiClient, test.pvc)
+		if mismatch != test.mismatch {
+			t.Errorf("%s with annotations %v: got mismatch %t (%s) instead of %t", *test.pvc.Spec.StorageClassName, test.pvc.Annotations, mismatch, reason, test.mismatch)
+		}
+	}
+}
@@ -3,15 +3,16 @@ package providers
 import (
 	"cloud.google.com/go/storage"
 	"context"
-	"errors"
+	"fmt"
 	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
-	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
+	"google.golang.org/api/option"
 	"k8s.io/api/core/v1"
-	storagev1 "k8s.io/api/storage/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"net/http"
+	"strings"
+	"time"
 )
 
 // GoogleProvider holds info about Google provider and allows us to implement the common interface
@@ -19,33 +20,25 @@ type GoogleProvider struct {
 	projectId string
 }
 
-// CreateStorageClass creates a StorageClass based on specs described on PVC
-func (gke *GoogleProvider) CreateStorageClass(pvc *v1.PersistentVolumeClaim) error {
-	logrus.Info("Creating new storage class")
-	provisioner, err := gke.determineProvisioner(pvc)
+// Name returns the name of the provider
+func (gke *GoogleProvider) Name() string {
+	return "google"
+}
+
+// DesiredStorageClass builds the StorageClass based on specs described on PVC and the matching profile
+func (gke *GoogleProvider) DesiredStorageClass(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*DesiredState, error) {
+	logrus.Info("Building new storage class")
+	provisioner, err := gke.DetermineProvisioner(pvc, profile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logrus.Info("Determining provisioner succeeded")
-	parameter, err := gke.determineParameters(pvc)
+	parameter, err := gke.DetermineParameters(pvc, provisioner)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	logrus.Info("Determining parameter succeeded")
-	return sdk.Create(&storagev1.StorageClass{
-		TypeMeta: metav1.TypeMeta{
-			Kind:       "StorageClass",
-			APIVersion: "storage.k8s.io/v1",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name:            *pvc.Spec.StorageClassName,
-			Annotations:     nil,
-			OwnerReferences: nil,
-		},
-		Provisioner:  provisioner,
-		MountOptions: nil,
-		Parameters:   parameter,
-	})
+	return StorageClassState(gke.Name(), pvc, provisioner, parameter), nil
 }
 
 // GenerateMetadata generates metadata which are needed to create a StorageClass
@@ -53,74 +46,87 @@ func (gke *GoogleProvider) GenerateMetadata() error {
 	return nil
 }
 
-// determineParameters determines the access mode from PVC
-func (gke *GoogleProvider) determineParameters(pvc *v1.PersistentVolumeClaim) (map[string]string, error) {
-	//var parameter = map[string]string{}
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce", "ReadOnlyMany":
-			return nil, nil
-		}
+// DetermineParameters determines the StorageClass parameters of the chosen provisioner, the tier and the encryption
+// of the PVC
+func (gke *GoogleProvider) DetermineParameters(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) (map[string]string, error) {
+	parameter, err := ApplyTier(pvc, provisioner, CopyParameters(provisioner))
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("could not determine parameters")
+	return ApplyEncryption(pvc, provisioner, parameter)
 }
 
-// determineProvisioner determines what kind of provisioner should the storage class use
-func (gke *GoogleProvider) determineProvisioner(pvc *v1.PersistentVolumeClaim) (string, error) {
-	for _, mode := range pvc.Spec.AccessModes {
-		switch mode {
-		case "ReadWriteOnce", "ReadOnlyMany":
-			return "kubernetes.io/gce-pd", nil
-		case "ReadWriteMany":
-			return "", errors.New("Not supported yet")
-		}
-	}
-	return "", errors.New("AccessMode is missing from the PVC")
+// DetermineProvisioner determines what kind of provisioner should the storage class use
+func (gke *GoogleProvider) DetermineProvisioner(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error) {
+	return ResolveProvisioner(gke.Name(), pvc, profile)
 }
 
 // determineProjectId determines the project ID from the metadata server
 func (gke *GoogleProvider) determineProjectId() error {
 	logrus.Info("Getting ProjectID from Metadata service")
-	req, err := http.NewRequest("GET", "http://169.254.169.254/0.1/meta-data/project-id", nil)
+	projectId, err := metadataClient().GCEProject()
 	if err != nil {
 		logrus.Errorf("Error during getting project-id, %s", err.Error())
 		return err
 	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		logrus.Errorf("Error during getting project-id, %s", err.Error())
-		return err
-	}
-	readProjectId, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logrus.Errorf("Error during reading response %s", err.Error())
-		return err
-	}
-	gke.projectId = string(readProjectId)
+	gke.projectId = projectId
 	return nil
 }
 
-// CreateObjectStoreBucket creates a bucket in a cloud specific object store
-func (gke *GoogleProvider) CreateObjectStoreBucket(app *v1alpha1.ObjectStore) error {
+// DesiredBucket builds the bucket of the ObjectStore in the project of the cluster
+func (gke *GoogleProvider) DesiredBucket(app *v1alpha1.ObjectStore) (*DesiredState, error) {
+	if err := gke.determineProjectId(); err != nil {
+		return nil, err
+	}
+	projectId := gke.projectId
+	return &DesiredState{
+		Provider: gke.Name(),
+		Actions: []Action{{
+			Description: fmt.Sprintf("create bucket %s in project %s", app.Spec.Name, projectId),
+			Apply: func() error {
+				return gke.createBucket(app, projectId)
+			},
+		}},
+	}, nil
+}
+
+// createBucket creates the bucket of the ObjectStore in the project
+func (gke *GoogleProvider) createBucket(app *v1alpha1.ObjectStore, projectId string) error {
 	ctx := context.Background()
 	logrus.Info("Creating new storage client")
-	client, err := storage.NewClient(ctx)
+	client, err := storage.NewClient(ctx, googleStorageOptions(config.Get().Endpoints)...)
 	if err != nil {
-		logrus.Fatalf("Failed to create client: %v", err)
+		logrus.Errorf("Failed to create client: %v", err)
 		return err
 	}
 	logrus.Info("Storage client created successfully")
 
 	bucket := client.Bucket(app.Spec.Name)
-	gke.determineProjectId()
-	if err := bucket.Create(ctx, gke.projectId, nil); err != nil {
-		logrus.Fatalf("Failed to create bucket: %v", err)
+	start := time.Now()
+	err = bucket.Create(ctx, projectId, nil)
+	metrics.ObserveSince(metrics.CloudAPIDuration, start, gke.Name(), "create_bucket")
+	if err != nil {
+		logrus.Errorf("Failed to create bucket: %v", err)
 		return err
 	}
-	logrus.Infof("%s bucket created", app.Spec.Name)
+	metrics.BucketsCreated.WithLabelValues(gke.Name()).Inc()
+	events.Normal(app, events.BucketCreated, "created bucket %s in project %s", app.Spec.Name, projectId)
 	return nil
 }
 
+// googleStorageOptions points the storage client to the configured endpoint, the authentication is only skipped for
+// an overridden endpoint
+func googleStorageOptions(endpoints config.EndpointConfig) []option.ClientOption {
+	if endpoints.GoogleStorage == "" {
+		return nil
+	}
+	options := []option.ClientOption{option.WithEndpoint(strings.TrimSuffix(endpoints.GoogleStorage, "/") + "/storage/v1/")}
+	if endpoints.GoogleStorageWithoutAuthentication {
+		options = append(options, option.WithoutAuthentication())
+	}
+	return options
+}
+
 // CheckBucketExistence checks if the bucket already exists
 func (gke *GoogleProvider) CheckBucketExistence(app *v1alpha1.ObjectStore) (bool, error) {
 	return false, nil
@@ -0,0 +1,25 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+)
+
+func TestGoogleStorageOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints config.EndpointConfig
+		options   int
+	}{
+		{name: "default endpoint", endpoints: config.EndpointConfig{}, options: 0},
+		{name: "default endpoint without authentication", endpoints: config.EndpointConfig{GoogleStorageWithoutAuthentication: true}, options: 0},
+		{name: "overridden endpoint", endpoints: config.EndpointConfig{GoogleStorage: "http://127.0.0.1:8080"}, options: 1},
+		{name: "overridden endpoint without authentication", endpoints: config.EndpointConfig{GoogleStorage: "http://127.0.0.1:8080", GoogleStorageWithoutAuthentication: true}, options: 2},
+	}
+	for _, test := range tests {
+		if options := googleStorageOptions(test.endpoints); len(options) != test.options {
+			t.Errorf("%s: got %d client options instead of %d", test.name, len(options), test.options)
+		}
+	}
+}
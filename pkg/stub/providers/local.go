@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// localPathProvisioner is the provisioner of the local-path-provisioner shipped with kind and k3s, bare-metal
+	// clusters have to deploy it
+	localPathProvisioner = "rancher.io/local-path"
+	// minikubeHostPathProvisioner is the storage-provisioner addon of minikube
+	minikubeHostPathProvisioner = "k8s.io/minikube-hostpath"
+	// dockerDesktopHostPathProvisioner is the provisioner shipped with the Kubernetes of Docker Desktop
+	dockerDesktopHostPathProvisioner = "docker.io/hostpath"
+)
+
+// LocalProvider serves clusters without a cloud provider, like kind, minikube or bare-metal, ReadWriteOnce claims
+// get node local volumes while shared access modes are served by the Nfs provisioner
+type LocalProvider struct {
+	client client.Client
+	// provisioner serves the ReadWriteOnce claims, it depends on the environment
+	provisioner string
+}
+
+// Name returns the name of the provider
+func (local *LocalProvider) Name() string {
+	return "local"
+}
+
+// DesiredStorageClass builds the StorageClass based on specs described on PVC and the matching profile
+func (local *LocalProvider) DesiredStorageClass(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*DesiredState, error) {
+	logrus.Info("Building new storage class")
+	provisioner, err := local.DetermineProvisioner(pvc, profile)
+	if err != nil {
+		return nil, err
+	}
+	if provisioner.Provisioner == NfsProvisioner {
+		logrus.Infof("Serving StorageClass %s with the Nfs provisioner", *pvc.Spec.StorageClassName)
+		return NfsState(local.client, pvc), nil
+	}
+	logrus.Info("Determining provisioner succeeded")
+	parameter, err := local.DetermineParameters(pvc, provisioner)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Info("Determining parameter succeeded")
+	return StorageClassState(local.Name(), pvc, provisioner, parameter), nil
+}
+
+// GenerateMetadata generates metadata which are needed to create a StorageClass, the provisioner of the environment is
+// taken from the configuration or picked by the Nodes
+func (local *LocalProvider) GenerateMetadata() error {
+	local.provisioner = localProvisioner(local.client)
+	return nil
+}
+
+// localProvisioner returns the configured local provisioner, without one it is picked by the environment the Nodes run
+// in and falls back to the local-path-provisioner
+func localProvisioner(apiClient client.Client) string {
+	if provisioner := config.Get().Local.Provisioner; provisioner != "" {
+		return provisioner
+	}
+	nodes := &v1.NodeList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Node",
+			APIVersion: "v1",
+		},
+	}
+	if err := apiClient.List("", nodes, ""); err != nil {
+		logrus.Warnf("Could not list the Nodes to pick the local provisioner, using %s: %s", localPathProvisioner, err.Error())
+		return localPathProvisioner
+	}
+	for i := range nodes.Items {
+		if provisioner := environmentProvisioner(&nodes.Items[i]); provisioner != "" {
+			logrus.Infof("Using the local provisioner %s of Node %s", provisioner, nodes.Items[i].Name)
+			return provisioner
+		}
+	}
+	logrus.Infof("No Node runs in a known environment, using the local provisioner %s", localPathProvisioner)
+	return localPathProvisioner
+}
+
+// environmentProvisioner returns the provisioner shipped with the environment the Node runs in, empty if the
+// environment is unknown
+func environmentProvisioner(node *v1.Node) string {
+	switch {
+	case node.Labels["minikube.k8s.io/name"] != "":
+		return minikubeHostPathProvisioner
+	case node.Name == "docker-desktop":
+		return dockerDesktopHostPathProvisioner
+	case strings.HasPrefix(node.Spec.ProviderID, "kind://"), node.Labels["node.kubernetes.io/instance-type"] == "k3s":
+		return localPathProvisioner
+	}
+	return ""
+}
+
+// DetermineParameters determines the StorageClass parameters of the chosen provisioner
+func (local *LocalProvider) DetermineParameters(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) (map[string]string, error) {
+	return CopyParameters(provisioner), nil
+}
+
+// DetermineProvisioner determines what kind of provisioner should the storage class use, shared access modes
+// without a local provisioner in the profile fall back to Nfs and profile entries without a provisioner get the one of
+// the environment
+func (local *LocalProvider) DetermineProvisioner(pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error) {
+	provisioner, err := ResolveProvisioner(local.Name(), pvc, profile)
+	if accessModeErr, ok := err.(*UnsupportedAccessModeError); ok && accessModeErr.AccessMode != v1.ReadWriteOnce {
+		return &v1alpha1.ProvisionerSpec{
+			Provider:    local.Name(),
+			AccessModes: []v1.PersistentVolumeAccessMode{accessModeErr.AccessMode},
+			Provisioner: NfsProvisioner,
+		}, nil
+	}
+	if err != nil || provisioner.Provisioner != "" {
+		return provisioner, err
+	}
+	if local.provisioner == "" {
+		local.provisioner = localProvisioner(local.client)
+	}
+	provisioner = provisioner.DeepCopy()
+	provisioner.Provisioner = local.provisioner
+	return provisioner, nil
+}
+
+// CheckBucketExistence checks if the bucket already exists
+func (local *LocalProvider) CheckBucketExistence(store *v1alpha1.ObjectStore) (bool, error) {
+	return false, nil
+}
+
+// DesiredBucket builds the bucket in a cloud specific object store, the provider creates none
+func (local *LocalProvider) DesiredBucket(store *v1alpha1.ObjectStore) (*DesiredState, error) {
+	return &DesiredState{Provider: local.Name()}, nil
+}
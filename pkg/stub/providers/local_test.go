@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// node returns a Node with the name, providerID and labels
+func node(name, providerID string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Node",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: v1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func TestLocalProvisioner(t *testing.T) {
+	tests := []struct {
+		name        string
+		node        *v1.Node
+		configured  string
+		provisioner string
+	}{
+		{name: "minikube", node: node("minikube", "", map[string]string{"minikube.k8s.io/name": "minikube"}), provisioner: minikubeHostPathProvisioner},
+		{name: "docker desktop", node: node("docker-desktop", "", nil), provisioner: dockerDesktopHostPathProvisioner},
+		{name: "kind", node: node("kind-control-plane", "kind://docker/kind/kind-control-plane", nil), provisioner: localPathProvisioner},
+		{name: "k3s", node: node("server", "k3s://server", map[string]string{"node.kubernetes.io/instance-type": "k3s"}), provisioner: localPathProvisioner},
+		{name: "bare metal", node: node("worker", "", nil), provisioner: localPathProvisioner},
+		{name: "configured", node: node("minikube", "", map[string]string{"minikube.k8s.io/name": "minikube"}), configured: "example.com/local", provisioner: "example.com/local"},
+	}
+	defer config.Set(config.Default())
+	for _, test := range tests {
+		operatorConfig := config.Default()
+		operatorConfig.Local.Provisioner = test.configured
+		config.Set(operatorConfig)
+		apiClient := fake.NewClient(test.node)
+		local, err := NewProvider(apiClient, "local")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := local.GenerateMetadata(); err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		profile, err := FindStorageProfile(apiClient, "local-rwo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		provisioner, err := local.DetermineProvisioner(claim("local-rwo", v1.ReadWriteOnce), profile)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		if provisioner.Provisioner != test.provisioner {
+			t.Errorf("%s: got provisioner %s instead of %s", test.name, provisioner.Provisioner, test.provisioner)
+		}
+	}
+}
+
+func TestDetermineProviderFallsBackToLocal(t *testing.T) {
+	apiClient := fake.NewClient(node("worker", "", nil))
+	if provider, err := DetermineProvider(apiClient, []string{config.DetectionNode}); err == nil {
+		t.Errorf("got provider %s although no strategy detected one", provider.Name())
+	}
+	provider, err := DetermineProvider(apiClient, []string{config.DetectionNode, config.DetectionLocal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.Name() != "local" {
+		t.Errorf("got provider %s instead of local", provider.Name())
+	}
+}
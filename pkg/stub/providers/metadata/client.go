@@ -0,0 +1,276 @@
+package metadata
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultEndpoint is the link-local address every provider serves its instance metadata on
+	DefaultEndpoint = "http://169.254.169.254"
+
+	defaultTimeout    = 2 * time.Second
+	defaultRetries    = 2
+	defaultRetryDelay = 200 * time.Millisecond
+
+	awsTokenTTL         = 6 * time.Hour
+	awsTokenHeader      = "X-aws-ec2-metadata-token"
+	awsTokenTTLHeader   = "X-aws-ec2-metadata-token-ttl-seconds"
+	gceFlavorHeader     = "Metadata-Flavor"
+	gceFlavor           = "Google"
+	azureMetadataHeader = "Metadata"
+	azureAPIVersion     = "2017-12-01"
+	maxResponseSize     = 64 * 1024
+)
+
+var (
+	clientsMu sync.Mutex
+	// clients holds the shared client of every endpoint so the cached values survive
+	clients = map[string]*Client{}
+)
+
+// For returns the client shared by the providers for endpoint, DefaultEndpoint is used if it is empty
+func For(endpoint string) *Client {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	client, ok := clients[endpoint]
+	if !ok {
+		client = NewClient(endpoint)
+		clients[endpoint] = client
+	}
+	return client
+}
+
+// StatusError is returned when the metadata server answers with an unexpected status code
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("metadata request %s failed with status %d", e.URL, e.StatusCode)
+}
+
+// Client reads the instance metadata of AWS, Google and Azure, values are cached as they do not change during the
+// lifetime of an instance
+type Client struct {
+	endpoint   string
+	http       *http.Client
+	retries    int
+	retryDelay time.Duration
+
+	mu    sync.Mutex
+	cache map[string]string
+
+	tokenMu        sync.Mutex
+	awsToken       string
+	awsTokenExpiry time.Time
+}
+
+// NewClient creates a Client reading the metadata server at endpoint
+func NewClient(endpoint string) *Client {
+	return &Client{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		http:       &http.Client{Timeout: defaultTimeout},
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		cache:      map[string]string{},
+	}
+}
+
+// AWS returns the value at path of the AWS instance metadata, an IMDSv2 session token is used when the server
+// hands one out
+func (c *Client) AWS(path string) (string, error) {
+	return c.cached("aws/"+path, func() (string, error) {
+		headers := map[string]string{}
+		token, err := c.awsSessionToken()
+		if err != nil {
+			return "", err
+		}
+		if token != "" {
+			headers[awsTokenHeader] = token
+		}
+		return c.get(c.endpoint+"/latest/meta-data/"+path, headers, nil)
+	})
+}
+
+// GCE returns the value at path of the Google Compute Engine metadata
+func (c *Client) GCE(path string) (string, error) {
+	return c.cached("gce/"+path, func() (string, error) {
+		return c.get(c.endpoint+"/computeMetadata/v1/"+path, map[string]string{gceFlavorHeader: gceFlavor}, func(resp *http.Response) error {
+			if resp.Header.Get(gceFlavorHeader) != gceFlavor {
+				return fmt.Errorf("metadata server did not answer as Google")
+			}
+			return nil
+		})
+	})
+}
+
+// Azure returns the value at path of the Azure instance metadata, e.g. compute/location
+func (c *Client) Azure(path string) (string, error) {
+	return c.cached("azure/"+path, func() (string, error) {
+		url := fmt.Sprintf("%s/metadata/instance/%s?api-version=%s&format=text", c.endpoint, path, azureAPIVersion)
+		return c.get(url, map[string]string{azureMetadataHeader: "true"}, nil)
+	})
+}
+
+// AWSZone returns the availability zone of the instance
+func (c *Client) AWSZone() (string, error) {
+	return c.AWS("placement/availability-zone")
+}
+
+// AWSRegion returns the region of the instance
+func (c *Client) AWSRegion() (string, error) {
+	zone, err := c.AWSZone()
+	if err != nil {
+		return "", err
+	}
+	if len(zone) < 2 {
+		return "", fmt.Errorf("invalid availability zone %q", zone)
+	}
+	return zone[:len(zone)-1], nil
+}
+
+// GCEProject returns the project ID of the instance
+func (c *Client) GCEProject() (string, error) {
+	return c.GCE("project/project-id")
+}
+
+// GCEZone returns the zone of the instance
+func (c *Client) GCEZone() (string, error) {
+	zone, err := c.GCE("instance/zone")
+	if err != nil {
+		return "", err
+	}
+	return zone[strings.LastIndex(zone, "/")+1:], nil
+}
+
+// AzureLocation returns the location of the instance
+func (c *Client) AzureLocation() (string, error) {
+	return c.Azure("compute/location")
+}
+
+// AzureSubscriptionID returns the subscription of the instance
+func (c *Client) AzureSubscriptionID() (string, error) {
+	return c.Azure("compute/subscriptionId")
+}
+
+// AzureResourceGroup returns the resource group of the instance
+func (c *Client) AzureResourceGroup() (string, error) {
+	return c.Azure("compute/resourceGroupName")
+}
+
+// cached returns the cached value of key, fetching it on a miss
+func (c *Client) cached(key string, fetch func() (string, error)) (string, error) {
+	c.mu.Lock()
+	value, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.cache[key] = value
+	c.mu.Unlock()
+	return value, nil
+}
+
+// awsSessionToken returns a valid IMDSv2 session token, empty if the server only speaks IMDSv1
+func (c *Client) awsSessionToken() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.awsToken != "" && time.Now().Before(c.awsTokenExpiry) {
+		return c.awsToken, nil
+	}
+	token, err := c.do("PUT", c.endpoint+"/latest/api/token", map[string]string{
+		awsTokenTTLHeader: fmt.Sprintf("%d", int(awsTokenTTL.Seconds())),
+	}, nil)
+	if err != nil {
+		if !imdsV2Unavailable(err) {
+			return "", err
+		}
+		logrus.Infof("Metadata server does not hand out IMDSv2 session tokens, falling back to IMDSv1: %s", err)
+		return "", nil
+	}
+	c.awsToken = token
+	// renew the token a minute before it expires
+	c.awsTokenExpiry = time.Now().Add(awsTokenTTL - time.Minute)
+	return token, nil
+}
+
+// imdsV2Unavailable tells if the failure of the token request means IMDSv2 can not be used, a server without IMDSv2
+// rejects the PUT, while a hop limit too low for the pod drops the response so the request fails or times out
+func imdsV2Unavailable(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	if !ok {
+		return true
+	}
+	return statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusMethodNotAllowed
+}
+
+// get reads url with retries
+func (c *Client) get(url string, headers map[string]string, check func(*http.Response) error) (string, error) {
+	return c.do("GET", url, headers, check)
+}
+
+// do sends the request, retrying connection errors and server side failures
+func (c *Client) do(method, url string, headers map[string]string, check func(*http.Response) error) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.retryDelay * time.Duration(attempt))
+		}
+		value, retry, err := c.doOnce(method, url, headers, check)
+		if err == nil {
+			return value, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return "", lastErr
+}
+
+// doOnce sends the request once and tells if a failure is worth retrying
+func (c *Client) doOnce(method, url string, headers map[string]string, check func(*http.Response) error) (string, bool, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		retry := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return "", retry, &StatusError{URL: url, StatusCode: resp.StatusCode}
+	}
+	if check != nil {
+		if err := check(resp); err != nil {
+			return "", false, err
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", true, err
+	}
+	return strings.TrimSpace(string(body)), false, nil
+}
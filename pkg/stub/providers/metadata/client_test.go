@@ -0,0 +1,282 @@
+package metadata
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is a metadata server counting the requests it receives per method and path
+type fakeServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeServer(handler http.HandlerFunc) *fakeServer {
+	server := &fakeServer{requests: map[string]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests[r.Method+" "+r.URL.Path]++
+		server.mu.Unlock()
+		handler(w, r)
+	}))
+	return server
+}
+
+func (s *fakeServer) count(request string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[request]
+}
+
+// testClient returns a client of server that does not wait between retries
+func testClient(server *fakeServer) *Client {
+	client := NewClient(server.URL)
+	client.retryDelay = 0
+	return client
+}
+
+// awsHandler answers like an IMDSv2 server handing out token
+func awsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT" && r.URL.Path == "/latest/api/token":
+			if r.Header.Get(awsTokenTTLHeader) == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, token)
+		case r.Method == "GET" && r.URL.Path == "/latest/meta-data/placement/availability-zone":
+			if r.Header.Get(awsTokenHeader) != token {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, "eu-west-1a\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestAWSSessionToken(t *testing.T) {
+	server := newFakeServer(awsHandler("token"))
+	defer server.Close()
+	client := testClient(server)
+
+	region, err := client.AWSRegion()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if region != "eu-west-1" {
+		t.Errorf("expected region eu-west-1, got %q", region)
+	}
+
+	if _, err := client.AWS("instance-id"); err == nil {
+		t.Error("expected an error for a path the server does not know")
+	}
+	if got := server.count("PUT /latest/api/token"); got != 1 {
+		t.Errorf("expected the token to be requested once and reused, got %d requests", got)
+	}
+
+	client.tokenMu.Lock()
+	client.awsTokenExpiry = time.Now().Add(-time.Second)
+	client.tokenMu.Unlock()
+	if _, err := client.AWS("ami-id"); err == nil {
+		t.Error("expected an error for a path the server does not know")
+	}
+	if got := server.count("PUT /latest/api/token"); got != 2 {
+		t.Errorf("expected an expired token to be renewed, got %d token requests", got)
+	}
+}
+
+func TestAWSFallsBackToIMDSv1(t *testing.T) {
+	tests := []struct {
+		name  string
+		token http.HandlerFunc
+	}{
+		{
+			name:  "not found",
+			token: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+		},
+		{
+			name:  "method not allowed",
+			token: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusMethodNotAllowed) },
+		},
+		{
+			name: "connection reset",
+			token: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+		},
+		{
+			name:  "timeout",
+			token: func(w http.ResponseWriter, r *http.Request) { time.Sleep(200 * time.Millisecond) },
+		},
+	}
+	for _, test := range tests {
+		server := newFakeServer(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/latest/api/token" {
+				test.token(w, r)
+				return
+			}
+			if r.Header.Get(awsTokenHeader) != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "us-east-1b")
+		})
+		client := testClient(server)
+		client.http.Timeout = 50 * time.Millisecond
+
+		zone, err := client.AWSZone()
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if zone != "us-east-1b" {
+			t.Errorf("%s: expected zone us-east-1b, got %q", test.name, zone)
+		}
+	}
+}
+
+func TestAWSTokenRejected(t *testing.T) {
+	server := newFakeServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	defer server.Close()
+	client := testClient(server)
+
+	if _, err := client.AWSZone(); err == nil {
+		t.Fatal("expected an error when the server refuses the token request")
+	}
+	if got := server.count("GET /latest/meta-data/placement/availability-zone"); got != 0 {
+		t.Errorf("expected no IMDSv1 request after the token was refused, got %d", got)
+	}
+}
+
+func TestGCEFlavor(t *testing.T) {
+	tests := []struct {
+		name        string
+		answerAs    string
+		expectError bool
+	}{
+		{name: "google", answerAs: gceFlavor},
+		{name: "other server", answerAs: "", expectError: true},
+	}
+	for _, test := range tests {
+		server := newFakeServer(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(gceFlavorHeader) != gceFlavor {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if test.answerAs != "" {
+				w.Header().Set(gceFlavorHeader, test.answerAs)
+			}
+			fmt.Fprint(w, "projects/123/zones/europe-west1-b")
+		})
+		zone, err := testClient(server).GCEZone()
+		server.Close()
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if zone != "europe-west1-b" {
+			t.Errorf("%s: expected zone europe-west1-b, got %q", test.name, zone)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		expectError bool
+		requests    int
+	}{
+		{name: "success", statuses: []int{http.StatusOK}, requests: 1},
+		{name: "server error", statuses: []int{http.StatusInternalServerError, http.StatusOK}, requests: 2},
+		{name: "throttled", statuses: []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}, requests: 3},
+		{name: "retries exhausted", statuses: []int{http.StatusBadGateway}, expectError: true, requests: defaultRetries + 1},
+		{name: "client error", statuses: []int{http.StatusNotFound}, expectError: true, requests: 1},
+	}
+	for _, test := range tests {
+		var mu sync.Mutex
+		attempt := 0
+		server := newFakeServer(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			status := test.statuses[len(test.statuses)-1]
+			if attempt < len(test.statuses) {
+				status = test.statuses[attempt]
+			}
+			attempt++
+			mu.Unlock()
+			w.WriteHeader(status)
+			fmt.Fprint(w, "westeurope")
+		})
+		location, err := testClient(server).AzureLocation()
+		requests := server.count("GET /metadata/instance/compute/location")
+		server.Close()
+		if requests != test.requests {
+			t.Errorf("%s: expected %d requests, got %d", test.name, test.requests, requests)
+		}
+		if test.expectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		} else if location != "westeurope" {
+			t.Errorf("%s: expected location westeurope, got %q", test.name, location)
+		}
+	}
+}
+
+func TestCaching(t *testing.T) {
+	server := newFakeServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(azureMetadataHeader) != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("api-version") != azureAPIVersion {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "group")
+	})
+	defer server.Close()
+	client := testClient(server)
+
+	for i := 0; i < 3; i++ {
+		group, err := client.AzureResourceGroup()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if group != "group" {
+			t.Errorf("expected resource group group, got %q", group)
+		}
+	}
+	if got := server.count("GET /metadata/instance/compute/resourceGroupName"); got != 1 {
+		t.Errorf("expected the value to be fetched once, got %d requests", got)
+	}
+
+	if For(server.URL) != For(server.URL) {
+		t.Error("expected a shared client per endpoint")
+	}
+}
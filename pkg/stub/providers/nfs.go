@@ -2,6 +2,9 @@ package providers
 
 import (
 	"fmt"
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
 	"github.com/operator-framework/operator-sdk/pkg/sdk"
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/apps/v1beta1"
@@ -10,51 +13,79 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"os"
-	"strconv"
 )
 
 const (
-	nfsDepName           = "nfs-provisioner"
-	namespaceForNFS      = "NFS_NAMESPACE"
-	ownerRefName         = "OWNER_REFERENCE_NAME"
-	isRbacEnabled        = "RBAC_ENABLED"
-	nfsServiceAccountEnv = "NFS_SERVICE_ACCOUNT_NAME"
-	cpuRequestForNFS     = "NFS_CPU_REQUEST"
-)
+	// NfsProvisioner is the provisioner name served by the nfs-provisioner deployment
+	NfsProvisioner = "banzaicloud.com/nfs"
+	// NfsFallbackAnnotation records on a PVC and its StorageClass why the provider was replaced by the Nfs provisioner
+	NfsFallbackAnnotation = "banzaicloud.com/nfs-fallback-reason"
 
-// SetUpNfsProvisioner sets up a deployment a pvc and a service to handle nfs workload
-func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
-	logrus.Info("Creating new PersistentVolumeClaim for Nfs provisioner...")
+	nfsDepName = "nfs-provisioner"
+	// nfsHeadroom is added to the storage requested from an Nfs StorageClass when sizing its backing PVC
+	nfsHeadroom = "2Gi"
+	// nfsProvider is the provider label of the Nfs StorageClasses in the metrics
+	nfsProvider = "nfs"
+)
 
-	const volumeName = "nfs-prov-volume"
+// NfsState returns the Nfs stack serving the StorageClass of the PVC
+func NfsState(apiClient client.Client, pv *v1.PersistentVolumeClaim) *DesiredState {
+	return &DesiredState{Provider: nfsProvider, Objects: NfsStack(apiClient, pv, nil)}
+}
 
-	nfsNamespace := os.Getenv(namespaceForNFS)
-	if nfsNamespace == "" {
-		nfsNamespace = "default"
+// NfsFallbackState returns the Nfs stack serving the StorageClass of the PVC as the provider cannot, the reason is
+// recorded in the NfsFallbackAnnotation of both
+func NfsFallbackState(apiClient client.Client, pv *v1.PersistentVolumeClaim, reason string) *DesiredState {
+	return &DesiredState{
+		Provider: nfsProvider,
+		Actions: []Action{{
+			Description: fmt.Sprintf("annotate PersistentVolumeClaim %s/%s with %s=%q", pv.Namespace, pv.Name, NfsFallbackAnnotation, reason),
+			Apply: func() error {
+				return annotateClaim(apiClient, pv, NfsFallbackAnnotation, reason)
+			},
+		}},
+		Objects: NfsStack(apiClient, pv, map[string]string{NfsFallbackAnnotation: reason}),
 	}
+}
 
-	nfsProvisionerCPURequest := resource.MustParse("250m")
-	if value := os.Getenv(cpuRequestForNFS); value != "" {
-		parsed, err := resource.ParseQuantity(value)
-		if err != nil {
-			logrus.Errorf("failed to parse quantity in env var %s=%q: %v", cpuRequestForNFS, value, err)
-		} else {
-			nfsProvisionerCPURequest = parsed
-		}
+// annotateClaim sets the annotation of the PVC unless it is already set
+func annotateClaim(apiClient client.Client, pv *v1.PersistentVolumeClaim, key, value string) error {
+	if pv.Annotations[key] == value {
+		return nil
+	}
+	claim := pv.DeepCopy()
+	if claim.Annotations == nil {
+		claim.Annotations = map[string]string{}
+	}
+	claim.Annotations[key] = value
+	if err := apiClient.Update(claim); err != nil {
+		logrus.Errorf("Error happened during annotating PersistentVolumeClaim %s/%s %s", pv.Namespace, pv.Name, err.Error())
+		return err
 	}
+	return nil
+}
 
-	plusStorage := resource.MustParse("2Gi")
-	parsedStorageSize := pv.Spec.Resources.Requests["storage"]
-	parsedStorageSize.Add(plusStorage)
+// NfsStack returns the objects serving the StorageClass of the PVC with the Nfs provisioner in the order they are
+// created: the data PVC unless the PVC asks for a local volume, the Service, the Deployment and the StorageClass
+func NfsStack(apiClient client.Client, pv *v1.PersistentVolumeClaim, annotations map[string]string) []sdk.Object {
+	const volumeName = "nfs-prov-volume"
+
+	nfsConfig := config.Get().Nfs
+	nfsNamespace := nfsConfig.Namespace
+
+	parsedStorageSize := resource.MustParse(nfsHeadroom)
+	parsedStorageSize.Add(pv.Spec.Resources.Requests[v1.ResourceStorage])
 	isLocalVolume := false
 
 	ownerRef := make([]metav1.OwnerReference, 0)
 
-	if os.Getenv(ownerRefName) != "" {
-		ownerRef = []metav1.OwnerReference{asOwner(getOwner())}
+	if config.Get().OwnerReferenceName != "" {
+		if owner := getOwner(apiClient); owner != nil {
+			ownerRef = []metav1.OwnerReference{asOwner(owner)}
+		}
 	}
 
+	var stack []sdk.Object
 	if pv.Annotations["localvolume"] == "true" {
 		isLocalVolume = true
 	}
@@ -83,14 +114,8 @@ func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
 		if len(ownerRef) != 0 {
 			nfsPvc.SetOwnerReferences(ownerRef)
 		}
-
-		err := sdk.Create(nfsPvc)
-		if err != nil && !errors.IsAlreadyExists(err) {
-			logrus.Errorf("Error happened during creating a PersistentVolumeClaim for Nfs %s", err.Error())
-			return err
-		}
+		stack = append(stack, nfsPvc)
 	}
-	logrus.Info("Creating new Service for Nfs provisioner..")
 	nfsSvc := &v1.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
@@ -118,13 +143,8 @@ func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
 	if len(ownerRef) != 0 {
 		nfsSvc.SetOwnerReferences(ownerRef)
 	}
+	stack = append(stack, nfsSvc)
 
-	err := sdk.Create(nfsSvc)
-	if err != nil && !errors.IsAlreadyExists(err) {
-		logrus.Errorf("Error happened during creating the Service for Nfs %s", err.Error())
-		return err
-	}
-	logrus.Info("Creating new Deployment for Nfs provisioner..")
 	replicas := int32(1)
 	nfsDepl := &v1beta1.Deployment{
 		TypeMeta: metav1.TypeMeta{
@@ -147,7 +167,7 @@ func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
 					Containers: []v1.Container{
 						{
 							Name:  nfsDepName,
-							Image: "quay.io/kubernetes_incubator/nfs-provisioner:v1.0.9",
+							Image: nfsConfig.Image,
 							Ports: []v1.ContainerPort{
 								{Name: "nfs", ContainerPort: 2049},
 								{Name: "mountd", ContainerPort: 20048},
@@ -173,9 +193,7 @@ func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
 							VolumeMounts: []v1.VolumeMount{
 								{Name: volumeName, MountPath: "/export"},
 							},
-							Resources: v1.ResourceRequirements{
-								Requests: v1.ResourceList{v1.ResourceCPU: nfsProvisionerCPURequest},
-							},
+							Resources: *nfsConfig.Resources.DeepCopy(),
 						},
 					},
 				},
@@ -205,56 +223,119 @@ func SetUpNfsProvisioner(pv *v1.PersistentVolumeClaim) error {
 	if len(ownerRef) != 0 {
 		nfsDepl.SetOwnerReferences(ownerRef)
 	}
-	rbacEnabled, err := strconv.ParseBool(os.Getenv(isRbacEnabled))
-	if err != nil {
-		return err
-	}
-	if rbacEnabled {
-		if serviceAcc := os.Getenv(nfsServiceAccountEnv); serviceAcc != "" {
-			nfsDepl.Spec.Template.Spec.ServiceAccountName = serviceAcc
-		}
+	if nfsConfig.RbacEnabled && nfsConfig.ServiceAccountName != "" {
+		nfsDepl.Spec.Template.Spec.ServiceAccountName = nfsConfig.ServiceAccountName
 	}
+	stack = append(stack, nfsDepl)
 
-	err = sdk.Create(nfsDepl)
-	if err != nil && !errors.IsAlreadyExists(err) {
-		logrus.Errorf("Error happened during creating the Deployment for Nfs %s", err.Error())
-		return err
-	}
-	logrus.Info("Creating new StorageClass for Nfs provisioner..")
 	reclaimPolicy := v1.PersistentVolumeReclaimRetain
+	allowVolumeExpansion := false
 	nfsStorageClass := &storagev1.StorageClass{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "StorageClass",
 			APIVersion: "storage.k8s.io/v1",
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name: *pv.Spec.StorageClassName,
+			Name:        *pv.Spec.StorageClassName,
+			Labels:      storageClassLabels(pv, v1alpha1.StorageTypeNfs),
+			Annotations: annotations,
+			Finalizers:  []string{StorageClassFinalizer},
 		},
 		ReclaimPolicy: &reclaimPolicy,
-		Provisioner:   "banzaicloud.com/nfs",
+		Provisioner:   NfsProvisioner,
+		// the Nfs provisioner cannot resize its volumes, the operator grows the shared backing PVC instead
+		AllowVolumeExpansion: &allowVolumeExpansion,
 	}
 	if len(ownerRef) != 0 {
 		nfsStorageClass.SetOwnerReferences(ownerRef)
 	}
-	err = sdk.Create(nfsStorageClass)
-	if err != nil && !errors.IsAlreadyExists(err) {
-		logrus.Errorf("Error happened during creating the StorageClass for Nfs %s", err.Error())
-		return err
+	return append(stack, nfsStorageClass)
+}
+
+// ExpandedNfsDataClaim returns the backing PVC of the Nfs StorageClass grown to the storage requested by the PVCs
+// using the class plus headroom, nil if it is large enough or there is none
+func ExpandedNfsDataClaim(apiClient client.Client, name string) (*v1.PersistentVolumeClaim, error) {
+	consumers, err := storageClassConsumers(apiClient, name)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	capacity := resource.MustParse(nfsHeadroom)
+	for _, consumer := range consumers {
+		capacity.Add(consumer.Spec.Resources.Requests[v1.ResourceStorage])
+	}
+	dataClaim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-data", name),
+			Namespace: getNfsNamespace(),
+		},
+	}
+	if err := apiClient.Get(dataClaim); err != nil {
+		if errors.IsNotFound(err) {
+			// classes of local volumes have no backing PVC
+			return nil, nil
+		}
+		return nil, err
+	}
+	current := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+	if capacity.Cmp(current) <= 0 {
+		return nil, nil
+	}
+	if dataClaim.Spec.Resources.Requests == nil {
+		dataClaim.Spec.Resources.Requests = v1.ResourceList{}
+	}
+	dataClaim.Spec.Resources.Requests[v1.ResourceStorage] = capacity
+	return dataClaim, nil
+}
+
+// ExpandableClaim returns the StorageClass of the PVC, the default StorageClass if it names none, and whether that
+// class allows growing the PVC
+func ExpandableClaim(apiClient client.Client, pvc *v1.PersistentVolumeClaim) (string, bool, error) {
+	var className string
+	if pvc.Spec.StorageClassName != nil {
+		className = *pvc.Spec.StorageClassName
+	} else {
+		defaultClassName, err := DefaultStorageClassName(apiClient)
+		if err != nil {
+			return "", false, err
+		}
+		className = defaultClassName
+	}
+	if className == "" {
+		return "", false, nil
+	}
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: className,
+		},
+	}
+	if err := apiClient.Get(storageClass); err != nil {
+		if errors.IsNotFound(err) {
+			return className, false, nil
+		}
+		return className, false, err
+	}
+	return className, storageClass.AllowVolumeExpansion != nil && *storageClass.AllowVolumeExpansion, nil
 }
 
 // CheckNfsServerExistence checks if the NFS deployment and all companion service exists
-func CheckNfsServerExistence(name, namespace string) bool {
-	if !CheckPersistentVolumeClaimExistence(fmt.Sprintf("%s-data", name), namespace) {
+func CheckNfsServerExistence(apiClient client.Client, name, namespace string) bool {
+	if !CheckPersistentVolumeClaimExistence(apiClient, fmt.Sprintf("%s-data", name), namespace) {
 		logrus.Info("PersistentVolume claim for Nfs does not exist!")
 		return false
 	}
-	if !checkNfsProviderDeployment() {
+	if !checkNfsProviderDeployment(apiClient) {
 		logrus.Info("Nfs provider deployment does not exist!")
 		return false
 	}
-	if !CheckStorageClassExistence(name) {
+	if !CheckStorageClassExistence(apiClient, name) {
 		logrus.Info("StorageClass for Nfs does not exist!")
 		return false
 	}
@@ -263,8 +344,8 @@ func CheckNfsServerExistence(name, namespace string) bool {
 }
 
 // checkNfsProviderDeployment checks if the NFS deployment exists
-func checkNfsProviderDeployment() bool {
-	nfsNamespace := os.Getenv(namespaceForNFS)
+func checkNfsProviderDeployment(apiClient client.Client) bool {
+	nfsNamespace := getNfsNamespace()
 	deployment := &v1beta1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Deployment",
@@ -301,14 +382,95 @@ func checkNfsProviderDeployment() bool {
 			Selector: map[string]string{"app": nfsDepName},
 		},
 	}
-	if err := sdk.Get(deployment); err != nil {
+	if err := apiClient.Get(deployment); err != nil {
 		logrus.Infof("Nfs provider deployment does not exist: %v", err)
 		return false
 	}
-	if err := sdk.Get(service); err != nil {
+	if err := apiClient.Get(service); err != nil {
 		logrus.Infof("Nfs provider service does not exist: %v", err)
 		return false
 	}
 	logrus.Info("Nfs provider exists!")
 	return true
 }
+
+// TearDownNfsProvisioner deletes the backing PersistentVolumeClaim of the given Nfs StorageClass.
+// The shared Deployment and Service are deleted together with the last Nfs StorageClass.
+func TearDownNfsProvisioner(apiClient client.Client, name string) error {
+	nfsNamespace := getNfsNamespace()
+	storageClasses, err := ListManagedStorageClasses(apiClient)
+	if err != nil {
+		return err
+	}
+	lastNfsClass := true
+	for _, storageClass := range storageClasses {
+		if storageClass.Provisioner == NfsProvisioner && storageClass.Name != name && storageClass.DeletionTimestamp == nil {
+			lastNfsClass = false
+			break
+		}
+	}
+	deployment := &v1beta1.Deployment{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Deployment",
+			APIVersion: "extensions/v1beta1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nfsDepName,
+			Namespace: nfsNamespace,
+		},
+	}
+	dataClaimName := fmt.Sprintf("%s-data", name)
+	if lastNfsClass {
+		logrus.Info("Deleting the Deployment and Service of the Nfs provisioner..")
+		service := &v1.Service{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Service",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      nfsDepName,
+				Namespace: nfsNamespace,
+			},
+		}
+		for _, object := range []sdk.Object{deployment, service} {
+			if err := apiClient.Delete(object); err != nil && !errors.IsNotFound(err) {
+				logrus.Errorf("Error happened during deleting the Nfs provisioner %s", err.Error())
+				return err
+			}
+		}
+	} else if err := apiClient.Get(deployment); err == nil && mountsClaim(deployment, dataClaimName) {
+		logrus.Infof("Nfs provisioner still serves other StorageClasses from %s, keeping it", dataClaimName)
+		return nil
+	}
+	logrus.Infof("Deleting the PersistentVolumeClaim %s of the Nfs provisioner..", dataClaimName)
+	dataClaim := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dataClaimName,
+			Namespace: nfsNamespace,
+		},
+	}
+	if err := apiClient.Delete(dataClaim); err != nil && !errors.IsNotFound(err) {
+		logrus.Errorf("Error happened during deleting the PersistentVolumeClaim for Nfs %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// mountsClaim checks if the Deployment mounts the given PersistentVolumeClaim
+func mountsClaim(deployment *v1beta1.Deployment, claimName string) bool {
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// getNfsNamespace returns the namespace of the Nfs provisioner
+func getNfsNamespace() string {
+	return config.Get().Nfs.Namespace
+}
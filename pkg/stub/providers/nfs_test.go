@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestApplyNfsState(t *testing.T) {
+	apiClient := fake.NewClient()
+	pvc := claim("nfs", v1.ReadWriteMany)
+	if err := Apply(apiClient, NfsState(apiClient, pvc), pvc); err != nil {
+		t.Fatal(err)
+	}
+	if !CheckNfsServerExistence(apiClient, "nfs", getNfsNamespace()) {
+		t.Error("the Nfs provisioner of StorageClass nfs was not created")
+	}
+}
+
+func TestExpandedNfsDataClaim(t *testing.T) {
+	apiClient := fake.NewClient()
+	first := claim("nfs", v1.ReadWriteMany)
+	if err := Apply(apiClient, NfsState(apiClient, first), first); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiClient.Create(first); err != nil {
+		t.Fatal(err)
+	}
+	dataClaim, err := ExpandedNfsDataClaim(apiClient, "nfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataClaim != nil {
+		size := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+		t.Fatalf("the backing PVC sized for the first claim was grown to %s", size.String())
+	}
+
+	second := claim("nfs", v1.ReadWriteMany)
+	second.Name = "second"
+	second.Spec.Resources.Requests[v1.ResourceStorage] = resource.MustParse("5Gi")
+	if err := apiClient.Create(second); err != nil {
+		t.Fatal(err)
+	}
+	dataClaim, err = ExpandedNfsDataClaim(apiClient, "nfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataClaim == nil {
+		t.Fatal("the backing PVC was not grown for the second claim")
+	}
+	size := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+	if want := resource.MustParse("8Gi"); size.Cmp(want) != 0 {
+		t.Errorf("the backing PVC was grown to %s instead of %s", size.String(), want.String())
+	}
+}
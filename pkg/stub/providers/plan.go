@@ -0,0 +1,24 @@
+package providers
+
+import (
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"k8s.io/api/core/v1"
+)
+
+// Plan builds what the operator would create for the PVC with the provider. Nothing is created and the provider
+// metadata is not read, so parameters taken from it are left empty.
+func Plan(apiClient client.Client, provider CommonProvider, pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*DesiredState, error) {
+	if profile.Spec.Nfs {
+		return NfsState(apiClient, pvc), nil
+	}
+	_, err := provider.DetermineProvisioner(pvc, profile)
+	if accessModeErr, ok := err.(*UnsupportedAccessModeError); ok && config.Get().Nfs.Fallback {
+		return NfsFallbackState(apiClient, pvc, accessModeErr.Error()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return provider.DesiredStorageClass(pvc, profile)
+}
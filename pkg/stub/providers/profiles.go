@@ -0,0 +1,190 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	azureDiskProvisioner = "kubernetes.io/azure-disk"
+	azureFileProvisioner = "kubernetes.io/azure-file"
+	awsEbsProvisioner    = "kubernetes.io/aws-ebs"
+	gcePdProvisioner     = "kubernetes.io/gce-pd"
+
+	awsEbsCSIProvisioner    = "ebs.csi.aws.com"
+	gcePdCSIProvisioner     = "pd.csi.storage.gke.io"
+	azureDiskCSIProvisioner = "disk.csi.azure.com"
+	azureFileCSIProvisioner = "file.csi.azure.com"
+)
+
+// expandableProvisioners are the provisioners whose volumes can be grown
+var expandableProvisioners = map[string]bool{
+	azureDiskProvisioner: true,
+	azureFileProvisioner: true,
+	awsEbsProvisioner:    true,
+	gcePdProvisioner:     true,
+
+	awsEbsCSIProvisioner:    true,
+	gcePdCSIProvisioner:     true,
+	azureDiskCSIProvisioner: true,
+	azureFileCSIProvisioner: true,
+}
+
+// DefaultStorageProfiles returns the built-in profiles, they are used when no StorageProfile
+// with a higher priority matches the StorageClass name
+func DefaultStorageProfiles() []v1alpha1.StorageProfile {
+	waitForFirstConsumer := storagev1.VolumeBindingWaitForFirstConsumer
+	return []v1alpha1.StorageProfile{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs"},
+			Spec: v1alpha1.StorageProfileSpec{
+				ClassNamePattern: "nfs",
+				Priority:         10,
+				Nfs:              true,
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.StorageProfileSpec{
+				ClassNamePattern: ".*",
+				Priority:         -100,
+				Provisioners: []v1alpha1.ProvisionerSpec{
+					{
+						Provider:    "azure",
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						Provisioner: azureDiskProvisioner,
+						Parameters:  map[string]string{skuName: "Standard_LRS", kind: "managed"},
+					},
+					{
+						Provider:    "azure",
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteMany, v1.ReadOnlyMany},
+						Provisioner: azureFileProvisioner,
+						Parameters:  map[string]string{skuName: "Standard_LRS"},
+					},
+					{
+						Provider:    "aws",
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						Provisioner: awsEbsProvisioner,
+					},
+					{
+						Provider:    "google",
+						AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce, v1.ReadOnlyMany},
+						Provisioner: gcePdProvisioner,
+					},
+					{
+						// the local provider fills in the provisioner of the environment
+						Provider:          "local",
+						AccessModes:       []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+						VolumeBindingMode: &waitForFirstConsumer,
+					},
+				},
+			},
+		},
+	}
+}
+
+// FindStorageProfile returns the profile with the highest priority whose pattern matches the
+// StorageClass name. StorageProfiles from the cluster win over built-in ones of the same priority.
+func FindStorageProfile(apiClient client.Client, className string) (*v1alpha1.StorageProfile, error) {
+	profileList := &v1alpha1.StorageProfileList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageProfile",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+	}
+	if err := apiClient.List(metav1.NamespaceAll, profileList, ""); err != nil {
+		return nil, fmt.Errorf("could not list StorageProfiles: %s", err.Error())
+	}
+	return matchStorageProfile(className, append(profileList.Items, DefaultStorageProfiles()...))
+}
+
+// matchStorageProfile picks the matching profile with the highest priority, keeping the order of
+// profiles with the same priority
+func matchStorageProfile(className string, profiles []v1alpha1.StorageProfile) (*v1alpha1.StorageProfile, error) {
+	sort.SliceStable(profiles, func(i, j int) bool {
+		return profiles[i].Spec.Priority > profiles[j].Spec.Priority
+	})
+	for i := range profiles {
+		pattern, err := regexp.Compile(profiles[i].Spec.ClassNamePattern)
+		if err != nil {
+			logrus.Errorf("Invalid class name pattern in StorageProfile %s: %s", profiles[i].Name, err.Error())
+			continue
+		}
+		if pattern.MatchString(className) {
+			logrus.Infof("StorageClass %s matches StorageProfile %s", className, profiles[i].Name)
+			return &profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no StorageProfile matches StorageClass %s", className)
+}
+
+// ResolveProvisioner returns the provisioner of the profile which serves the access modes of the PVC on the provider
+func ResolveProvisioner(provider string, pvc *v1.PersistentVolumeClaim, profile *v1alpha1.StorageProfile) (*v1alpha1.ProvisionerSpec, error) {
+	for _, mode := range pvc.Spec.AccessModes {
+		for i, provisioner := range profile.Spec.Provisioners {
+			if provisioner.Provider == provider && containsAccessMode(provisioner.AccessModes, mode) {
+				return &profile.Spec.Provisioners[i], nil
+			}
+		}
+		return nil, &UnsupportedAccessModeError{AccessMode: mode, Provider: provider}
+	}
+	return nil, errors.New("AccessMode is missing from the PVC")
+}
+
+// SupportsAccessModes checks if the provider can serve the access modes of the PVC with the StorageClass name
+func SupportsAccessModes(apiClient client.Client, provider CommonProvider, pvc *v1.PersistentVolumeClaim, className string) (bool, error) {
+	profile, err := FindStorageProfile(apiClient, className)
+	if err != nil {
+		return false, err
+	}
+	if profile.Spec.Nfs {
+		return true, nil
+	}
+	_, err = provider.DetermineProvisioner(pvc, profile)
+	if _, ok := err.(*UnsupportedAccessModeError); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// containsAccessMode checks if mode is in modes
+func containsAccessMode(modes []v1.PersistentVolumeAccessMode, mode v1.PersistentVolumeAccessMode) bool {
+	for _, m := range modes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// allowVolumeExpansion returns whether the StorageClass rendered from the provisioner allows growing volumes, the
+// profile overrides what the provisioner supports
+func allowVolumeExpansion(provisioner *v1alpha1.ProvisionerSpec) *bool {
+	if provisioner.AllowVolumeExpansion != nil {
+		allowed := *provisioner.AllowVolumeExpansion
+		return &allowed
+	}
+	allowed := expandableProvisioners[provisioner.Provisioner]
+	return &allowed
+}
+
+// CopyParameters returns a copy of the provisioner parameters which can be extended safely
+func CopyParameters(provisioner *v1alpha1.ProvisionerSpec) map[string]string {
+	if provisioner.Parameters == nil {
+		return nil
+	}
+	parameters := make(map[string]string, len(provisioner.Parameters))
+	for key, value := range provisioner.Parameters {
+		parameters[key] = value
+	}
+	return parameters
+}
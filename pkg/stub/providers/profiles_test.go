@@ -0,0 +1,125 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// claim returns a PVC of the default namespace requesting 1Gi of the StorageClass with the access mode
+func claim(className string, accessMode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      className + "-claim",
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &className,
+			AccessModes:      []v1.PersistentVolumeAccessMode{accessMode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+	}
+}
+
+// storageProfile returns a StorageProfile matching the pattern with the priority
+func storageProfile(name, pattern string, priority int32, provisioners ...v1alpha1.ProvisionerSpec) *v1alpha1.StorageProfile {
+	return &v1alpha1.StorageProfile{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageProfile",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: v1alpha1.StorageProfileSpec{
+			ClassNamePattern: pattern,
+			Priority:         priority,
+			Provisioners:     provisioners,
+		},
+	}
+}
+
+func TestFindStorageProfile(t *testing.T) {
+	apiClient := fake.NewClient(
+		storageProfile("fast", "^fast-", 0),
+		storageProfile("catch-all", ".*", -100),
+	)
+	tests := []struct {
+		className string
+		profile   string
+	}{
+		{className: "fast-ssd", profile: "fast"},
+		{className: "nfs", profile: "nfs"},
+		{className: "standard", profile: "catch-all"},
+	}
+	for _, test := range tests {
+		profile, err := FindStorageProfile(apiClient, test.className)
+		if err != nil {
+			t.Fatalf("%s: %s", test.className, err.Error())
+		}
+		if profile.Name != test.profile {
+			t.Errorf("%s: got StorageProfile %s instead of %s", test.className, profile.Name, test.profile)
+		}
+	}
+}
+
+func TestSupportsAccessModes(t *testing.T) {
+	apiClient := fake.NewClient()
+	aws, err := NewProvider(apiClient, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		className  string
+		accessMode v1.PersistentVolumeAccessMode
+		supported  bool
+	}{
+		{className: "standard", accessMode: v1.ReadWriteOnce, supported: true},
+		{className: "standard", accessMode: v1.ReadWriteMany, supported: false},
+		{className: "nfs", accessMode: v1.ReadWriteMany, supported: true},
+	}
+	for _, test := range tests {
+		supported, err := SupportsAccessModes(apiClient, aws, claim(test.className, test.accessMode), test.className)
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.className, test.accessMode, err.Error())
+		}
+		if supported != test.supported {
+			t.Errorf("%s %s: got supported %t instead of %t", test.className, test.accessMode, supported, test.supported)
+		}
+	}
+}
+
+func TestAllowVolumeExpansion(t *testing.T) {
+	disallowed := false
+	tests := []struct {
+		provisioner v1alpha1.ProvisionerSpec
+		allowed     bool
+	}{
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: azureDiskProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: azureFileProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: awsEbsProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: gcePdProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: awsEbsCSIProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: gcePdCSIProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: azureDiskCSIProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: azureFileCSIProvisioner}, allowed: true},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: "kubernetes.io/no-provisioner"}, allowed: false},
+		{provisioner: v1alpha1.ProvisionerSpec{Provisioner: awsEbsCSIProvisioner, AllowVolumeExpansion: &disallowed}, allowed: false},
+	}
+	for _, test := range tests {
+		allowed := allowVolumeExpansion(&test.provisioner)
+		if allowed == nil || *allowed != test.allowed {
+			t.Errorf("%s: expected allowVolumeExpansion %t, got %v", test.provisioner.Provisioner, test.allowed, allowed)
+		}
+	}
+}
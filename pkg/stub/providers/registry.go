@@ -0,0 +1,155 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers/metadata"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// Detector tells if the operator runs on a provider
+type Detector interface {
+	// ProbeMetadata checks if the metadata server of the provider answers using the given client
+	ProbeMetadata(client *metadata.Client) bool
+	// MatchesNode checks if the Node runs on the provider
+	MatchesNode(node *v1.Node) bool
+}
+
+// Constructor returns a new instance of a provider reaching the API server with the given client
+type Constructor func(apiClient client.Client) CommonProvider
+
+// registration holds what a provider registered
+type registration struct {
+	name        string
+	detector    Detector
+	constructor Constructor
+}
+
+var (
+	registryMu sync.RWMutex
+	// registry holds the providers in order of registration, which is their order of preference during detection
+	registry []registration
+)
+
+func init() {
+	Register("azure", &StandardDetector{
+		Name: "azure",
+		MetadataProbe: func(client *metadata.Client) error {
+			_, err := client.AzureLocation()
+			return err
+		},
+		ProviderIDPrefix: "azure://",
+		NodeLabel:        "kubernetes.azure.com/cluster",
+	}, func(client.Client) CommonProvider { return &AzureProvider{} })
+	Register("aws", &StandardDetector{
+		Name: "aws",
+		MetadataProbe: func(client *metadata.Client) error {
+			_, err := client.AWSZone()
+			return err
+		},
+		ProviderIDPrefix: "aws://",
+		NodeLabel:        "eks.amazonaws.com/nodegroup",
+	}, func(client.Client) CommonProvider { return &AwsProvider{} })
+	Register("google", &StandardDetector{
+		Name: "google",
+		MetadataProbe: func(client *metadata.Client) error {
+			_, err := client.GCEProject()
+			return err
+		},
+		ProviderIDPrefix: "gce://",
+		NodeLabel:        "cloud.google.com/gke-nodepool",
+	}, func(client.Client) CommonProvider { return &GoogleProvider{} })
+	Register("local", &StandardDetector{
+		Name:             "local",
+		ProviderIDPrefix: "kind://",
+		NodeLabel:        "minikube.k8s.io/name",
+	}, func(apiClient client.Client) CommonProvider { return &LocalProvider{client: apiClient} })
+}
+
+// Register adds a provider with the detector recognizing it and the constructor creating it, registering a name
+// again replaces the earlier registration
+func Register(name string, detector Detector, constructor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for i := range registry {
+		if registry[i].name == name {
+			registry[i] = registration{name: name, detector: detector, constructor: constructor}
+			return
+		}
+	}
+	registry = append(registry, registration{name: name, detector: detector, constructor: constructor})
+}
+
+// RegisteredProviders returns the names of the registered providers in order of preference
+func RegisteredProviders() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for _, r := range registry {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+// registrations returns a snapshot of the registered providers
+func registrations() []registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return append([]registration(nil), registry...)
+}
+
+// NewProvider returns the registered provider with the given name reaching the API server with the given client
+func NewProvider(apiClient client.Client, name string) (CommonProvider, error) {
+	for _, r := range registrations() {
+		if r.name == name {
+			return r.constructor(apiClient), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider %q, registered providers: %s", name, strings.Join(RegisteredProviders(), ", "))
+}
+
+// StandardDetector recognizes a provider by its metadata server, the scheme of the Node providerID or a Node label
+type StandardDetector struct {
+	// Name labels the probe metrics
+	Name string
+	// MetadataProbe reads a value only the metadata server of the provider serves
+	MetadataProbe func(client *metadata.Client) error
+	// ProviderIDPrefix is the scheme of Node.spec.providerID on the provider, e.g. aws://
+	ProviderIDPrefix string
+	// NodeLabel is a label the managed Kubernetes service of the provider sets on the Nodes
+	NodeLabel string
+}
+
+// ProbeMetadata checks if the metadata server of the provider answers
+func (d *StandardDetector) ProbeMetadata(client *metadata.Client) bool {
+	if d.MetadataProbe == nil {
+		return false
+	}
+	start := time.Now()
+	err := d.MetadataProbe(client)
+	metrics.ObserveSince(metrics.MetadataProbeDuration, start, d.Name)
+	if err != nil {
+		logrus.Infof("Metadata probe for %s failed: %s", d.Name, err.Error())
+		return false
+	}
+	return true
+}
+
+// MatchesNode checks the providerID and the labels of the Node
+func (d *StandardDetector) MatchesNode(node *v1.Node) bool {
+	if d.ProviderIDPrefix != "" && strings.HasPrefix(node.Spec.ProviderID, d.ProviderIDPrefix) {
+		return true
+	}
+	if d.NodeLabel != "" {
+		if _, ok := node.Labels[d.NodeLabel]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers/metadata"
+)
+
+// useRegistry returns a function restoring the registered providers
+func useRegistry() func() {
+	saved := registrations()
+	return func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		registry = saved
+	}
+}
+
+func TestRegister(t *testing.T) {
+	defer useRegistry()()
+	builtIn := RegisteredProviders()
+
+	// registering a name again replaces the provider but keeps its preference
+	Register("aws", &StandardDetector{Name: "aws"}, func(apiClient client.Client) CommonProvider {
+		return &LocalProvider{client: apiClient}
+	})
+	Register("openstack", &StandardDetector{Name: "openstack", ProviderIDPrefix: "openstack://"}, func(apiClient client.Client) CommonProvider {
+		return &LocalProvider{client: apiClient}
+	})
+	expected := strings.Join(append(builtIn, "openstack"), ",")
+	if registered := strings.Join(RegisteredProviders(), ","); registered != expected {
+		t.Errorf("got registered providers %s instead of %s", registered, expected)
+	}
+
+	tests := []struct {
+		name     string
+		provider string
+		unknown  bool
+	}{
+		{name: "aws", provider: "local"},
+		{name: "openstack", provider: "local"},
+		{name: "azure", provider: "azure"},
+		{name: "", unknown: true},
+		{name: "AWS", unknown: true},
+		{name: "digitalocean", unknown: true},
+	}
+	for _, test := range tests {
+		provider, err := NewProvider(fake.NewClient(), test.name)
+		if test.unknown {
+			if err == nil {
+				t.Errorf("%q: got provider %s instead of an error", test.name, provider.Name())
+			} else if !strings.Contains(err.Error(), "openstack") {
+				t.Errorf("%q: the error does not list the registered providers: %s", test.name, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.name, err.Error())
+		} else if provider.Name() != test.provider {
+			t.Errorf("%q: got provider %s instead of %s", test.name, provider.Name(), test.provider)
+		}
+	}
+}
+
+func TestStandardDetector(t *testing.T) {
+	detector := &StandardDetector{Name: "aws", ProviderIDPrefix: "aws://", NodeLabel: "eks.amazonaws.com/nodegroup"}
+	empty := &StandardDetector{Name: "empty"}
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		id      string
+		matches bool
+	}{
+		{name: "providerID", id: "aws:///eu-west-1a/i-0123456789abcdef0", matches: true},
+		{name: "label", labels: map[string]string{"eks.amazonaws.com/nodegroup": ""}, matches: true},
+		{name: "other providerID", id: "gce://project/zone/node", matches: false},
+		{name: "other label", labels: map[string]string{"eks.amazonaws.com/compute-type": "fargate"}, matches: false},
+		{name: "nothing", matches: false},
+	}
+	for _, test := range tests {
+		n := node("node", test.id, test.labels)
+		if matches := detector.MatchesNode(n); matches != test.matches {
+			t.Errorf("%s: got match %t instead of %t", test.name, matches, test.matches)
+		}
+		if empty.MatchesNode(n) {
+			t.Errorf("%s: a detector without providerID prefix and label matched", test.name)
+		}
+	}
+	if empty.ProbeMetadata(metadata.NewClient("http://127.0.0.1:0")) {
+		t.Error("a detector without metadata probe found its metadata server")
+	}
+}
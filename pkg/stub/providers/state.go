@@ -0,0 +1,129 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
+	"github.com/ghodss/yaml"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/apps/v1beta1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DesiredState is what the operator creates for a PVC or an ObjectStore. It is built without changing anything, so it
+// can be applied or, in dry-run mode, only reported.
+type DesiredState struct {
+	// Provider built the state, created StorageClasses are counted with it
+	Provider string
+	// Actions are the changes which are not object creations, like creating a storage account, applied before Objects
+	Actions []Action
+	// Objects are created in order, existing ones are left alone
+	Objects []sdk.Object
+}
+
+// Action is a change of the cloud or of an existing object
+type Action struct {
+	// Description tells what the action does, e.g. create storage account foo
+	Description string
+	// Apply carries the action out
+	Apply func() error
+}
+
+// Apply applies the state, Events about the created objects are recorded on the requester
+func Apply(apiClient client.Client, state *DesiredState, requester runtime.Object) error {
+	for _, action := range state.Actions {
+		logrus.Infof("Applying: %s", action.Description)
+		if err := action.Apply(); err != nil {
+			return err
+		}
+	}
+	for _, object := range state.Objects {
+		err := apiClient.Create(object)
+		if errors.IsAlreadyExists(err) {
+			logrus.Infof("%s already exists", describeObject(object))
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("Error happened during creating %s %s", describeObject(object), err.Error())
+			return err
+		}
+		recordCreated(state.Provider, object, requester)
+	}
+	return nil
+}
+
+// recordCreated counts the created object and records an Event about it on the requester
+func recordCreated(provider string, object sdk.Object, requester runtime.Object) {
+	switch o := object.(type) {
+	case *v1beta1.Deployment:
+		metrics.NfsStacksDeployed.Inc()
+		events.Normal(requester, events.NfsProvisionerCreated, "created Nfs provisioner %s in namespace %s", o.Name, o.Namespace)
+	case *storagev1.StorageClass:
+		metrics.StorageClassesCreated.WithLabelValues(provider, o.Provisioner).Inc()
+		events.Normal(requester, events.StorageClassCreated, "created StorageClass %s with provisioner %s", o.Name, o.Provisioner)
+	}
+}
+
+// Changes lists what applying the state would do
+func (s *DesiredState) Changes() []string {
+	var changes []string
+	for _, action := range s.Actions {
+		changes = append(changes, action.Description)
+	}
+	for _, object := range s.Objects {
+		changes = append(changes, "create "+describeObject(object))
+	}
+	return changes
+}
+
+// Report records what applying the state would do as Events on the requester and logs the objects it would create
+func Report(state *DesiredState, requester runtime.Object) {
+	for _, change := range state.Changes() {
+		events.Normal(requester, events.DryRun, "dry run, would %s", change)
+	}
+	for _, object := range state.Objects {
+		metrics.DryRunChanges.WithLabelValues(object.GetObjectKind().GroupVersionKind().Kind).Inc()
+	}
+	if len(state.Actions) != 0 {
+		metrics.DryRunChanges.WithLabelValues("Action").Add(float64(len(state.Actions)))
+	}
+	manifests, err := RenderManifests(state.Objects)
+	if err != nil {
+		logrus.Errorf("Could not render the dry run report: %s", err.Error())
+		return
+	}
+	logrus.Infof("Dry run, not applying:\n%s", manifests)
+}
+
+// RenderManifests renders the objects as a multi-document YAML stream
+func RenderManifests(objects []sdk.Object) (string, error) {
+	var manifests []string
+	for _, object := range objects {
+		manifest, err := yaml.Marshal(object)
+		if err != nil {
+			return "", err
+		}
+		manifests = append(manifests, "---\n"+string(manifest))
+	}
+	return strings.Join(manifests, ""), nil
+}
+
+// describeObject names the object with its kind, namespace and name
+func describeObject(object sdk.Object) string {
+	kind := object.GetObjectKind().GroupVersionKind().Kind
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return kind
+	}
+	if accessor.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", kind, accessor.GetName())
+	}
+	return fmt.Sprintf("%s %s/%s", kind, accessor.GetNamespace(), accessor.GetName())
+}
@@ -0,0 +1,189 @@
+package providers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TierAnnotation requests a performance tier for the volume of a PVC, StorageClasses created for a tier carry it as a
+// label
+const TierAnnotation = "banzaicloud.com/tier"
+
+// Performance tiers a PVC can request
+const (
+	TierFast       = "fast"
+	TierStandard   = "standard"
+	TierThroughput = "throughput"
+	TierCold       = "cold"
+)
+
+// tierParameters are the StorageClass parameters of the tiers per provisioner, the in-tree provisioners only know the
+// older disk types
+var tierParameters = map[string]map[string]map[string]string{
+	awsEbsProvisioner: {
+		TierFast:       {"type": "io1", "iopsPerGB": "50"},
+		TierStandard:   {"type": "gp2"},
+		TierThroughput: {"type": "st1"},
+		TierCold:       {"type": "sc1"},
+	},
+	awsEbsCSIProvisioner: {
+		TierFast:       {"type": "io2", "iopsPerGB": "50"},
+		TierStandard:   {"type": "gp3"},
+		TierThroughput: {"type": "st1"},
+		TierCold:       {"type": "sc1"},
+	},
+	gcePdProvisioner: {
+		TierFast:       {"type": "pd-ssd"},
+		TierStandard:   {"type": "pd-standard"},
+		TierThroughput: {"type": "pd-standard"},
+		TierCold:       {"type": "pd-standard"},
+	},
+	gcePdCSIProvisioner: {
+		TierFast:       {"type": "pd-ssd"},
+		TierStandard:   {"type": "pd-balanced"},
+		TierThroughput: {"type": "pd-standard"},
+		TierCold:       {"type": "pd-standard"},
+	},
+	azureDiskProvisioner: {
+		TierFast:       {skuName: "Premium_LRS"},
+		TierStandard:   {skuName: "StandardSSD_LRS"},
+		TierThroughput: {skuName: "Standard_LRS"},
+		TierCold:       {skuName: "Standard_LRS"},
+	},
+	azureDiskCSIProvisioner: {
+		TierFast:       {skuName: "Premium_LRS"},
+		TierStandard:   {skuName: "StandardSSD_LRS"},
+		TierThroughput: {skuName: "Standard_LRS"},
+		TierCold:       {skuName: "Standard_LRS"},
+	},
+	// Azure file shares are either premium or standard, they have no SSD backed standard tier
+	azureFileProvisioner: {
+		TierFast:       {skuName: "Premium_LRS"},
+		TierStandard:   {skuName: "Standard_LRS"},
+		TierThroughput: {skuName: "Standard_LRS"},
+		TierCold:       {skuName: "Standard_LRS"},
+	},
+	azureFileCSIProvisioner: {
+		TierFast:       {skuName: "Premium_LRS"},
+		TierStandard:   {skuName: "Standard_LRS"},
+		TierThroughput: {skuName: "Standard_LRS"},
+		TierCold:       {skuName: "Standard_LRS"},
+	},
+}
+
+// TierError is returned when the tier requested by a PVC is unknown or the chosen provisioner has no such tier
+type TierError struct {
+	Tier        string
+	Provisioner string
+}
+
+func (e *TierError) Error() string {
+	if e.Provisioner == "" {
+		return fmt.Sprintf("unknown tier %q in annotation %s, use one of %s", e.Tier, TierAnnotation, strings.Join(Tiers(), ", "))
+	}
+	return fmt.Sprintf("tier %s is not supported by provisioner %s", e.Tier, e.Provisioner)
+}
+
+// Tiers returns the names of the performance tiers
+func Tiers() []string {
+	return []string{TierFast, TierStandard, TierThroughput, TierCold}
+}
+
+// ClaimTier returns the performance tier requested by the PVC, empty if it requests none
+func ClaimTier(pvc *v1.PersistentVolumeClaim) (string, error) {
+	tier := pvc.Annotations[TierAnnotation]
+	if tier == "" {
+		return "", nil
+	}
+	for _, known := range Tiers() {
+		if tier == known {
+			return tier, nil
+		}
+	}
+	return "", &TierError{Tier: tier}
+}
+
+// TierClassName returns the StorageClass name serving the tier, the tier is appended so classes of different tiers
+// never collide
+func TierClassName(className, tier string) string {
+	if tier == "" || strings.HasSuffix(className, "-"+tier) {
+		return className
+	}
+	return className + "-" + tier
+}
+
+// ApplyTier sets the parameters of the tier requested by the PVC, they override the parameters of the profile. Cloud
+// providers call it from DetermineParameters. A *TierError is returned if the tier cannot be served.
+func ApplyTier(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec, parameters map[string]string) (map[string]string, error) {
+	tier, err := ClaimTier(pvc)
+	if err != nil || tier == "" {
+		return parameters, err
+	}
+	tiers, ok := tierParameters[provisioner.Provisioner]
+	if !ok {
+		return nil, &TierError{Tier: tier, Provisioner: provisioner.Provisioner}
+	}
+	if parameters == nil {
+		parameters = map[string]string{}
+	}
+	// parameters of the other tiers, like iopsPerGB, may not fit the requested one
+	for _, other := range tiers {
+		for key := range other {
+			delete(parameters, key)
+		}
+	}
+	for key, value := range tiers[tier] {
+		parameters[key] = value
+	}
+	return parameters, nil
+}
+
+// tierOf returns the tier of the PVC the provisioner serves, empty if it requests none or the provisioner has no tiers
+func tierOf(pvc *v1.PersistentVolumeClaim, provisioner *v1alpha1.ProvisionerSpec) string {
+	tier, err := ClaimTier(pvc)
+	if err != nil {
+		return ""
+	}
+	if _, ok := tierParameters[provisioner.Provisioner][tier]; !ok {
+		return ""
+	}
+	return tier
+}
+
+// MissingTierSuffix checks if the PVC requests a tier but its StorageClass name lacks the tier suffix TierClassName
+// adds, a class created under that name would serve claims of every tier
+func MissingTierSuffix(pvc *v1.PersistentVolumeClaim) bool {
+	tier, err := ClaimTier(pvc)
+	if err != nil || tier == "" || pvc.Spec.StorageClassName == nil {
+		return false
+	}
+	return TierClassName(*pvc.Spec.StorageClassName, tier) != *pvc.Spec.StorageClassName
+}
+
+// TierMismatch returns the tier of the existing StorageClass of the PVC and whether it differs from the requested one
+func TierMismatch(apiClient client.Client, pvc *v1.PersistentVolumeClaim) (string, bool) {
+	tier, err := ClaimTier(pvc)
+	if err != nil || tier == "" || pvc.Spec.StorageClassName == nil {
+		return "", false
+	}
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: *pvc.Spec.StorageClassName,
+		},
+	}
+	if err := apiClient.Get(storageClass); err != nil || !IsManagedStorageClass(storageClass) {
+		return "", false
+	}
+	classTier := storageClass.Labels[TierAnnotation]
+	return classTier, classTier != tier
+}
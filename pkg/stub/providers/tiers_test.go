@@ -0,0 +1,116 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+func TestApplyTier(t *testing.T) {
+	tests := []struct {
+		provisioner string
+		tier        string
+		diskType    string
+	}{
+		{provisioner: awsEbsProvisioner, tier: TierFast, diskType: "io1"},
+		{provisioner: awsEbsProvisioner, tier: TierStandard, diskType: "gp2"},
+		{provisioner: awsEbsCSIProvisioner, tier: TierFast, diskType: "io2"},
+		{provisioner: awsEbsCSIProvisioner, tier: TierStandard, diskType: "gp3"},
+		{provisioner: gcePdProvisioner, tier: TierStandard, diskType: "pd-standard"},
+		{provisioner: gcePdCSIProvisioner, tier: TierStandard, diskType: "pd-balanced"},
+	}
+	for _, test := range tests {
+		pvc := claim("data-"+test.tier, v1.ReadWriteOnce)
+		pvc.Annotations = map[string]string{TierAnnotation: test.tier}
+		provisioner := &v1alpha1.ProvisionerSpec{
+			Provisioner: test.provisioner,
+			Parameters:  map[string]string{"type": "gp2", "iopsPerGB": "10"},
+		}
+		parameters, err := ApplyTier(pvc, provisioner, CopyParameters(provisioner))
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.provisioner, test.tier, err.Error())
+		}
+		if parameters["type"] != test.diskType {
+			t.Errorf("%s %s: got disk type %s instead of %s", test.provisioner, test.tier, parameters["type"], test.diskType)
+		}
+		_, provisionedIops := tierParameters[test.provisioner][TierFast]["iopsPerGB"]
+		if provisionedIops && test.tier != TierFast && parameters["iopsPerGB"] != "" {
+			t.Errorf("%s %s: iopsPerGB of the profile was kept for another disk type", test.provisioner, test.tier)
+		}
+	}
+}
+
+func TestMissingTierSuffix(t *testing.T) {
+	tests := []struct {
+		className string
+		tier      string
+		missing   bool
+	}{
+		{className: "data", tier: "", missing: false},
+		{className: "data", tier: TierFast, missing: true},
+		{className: "data-fast", tier: TierFast, missing: false},
+		{className: "data-fast", tier: TierCold, missing: true},
+	}
+	for _, test := range tests {
+		pvc := claim(test.className, v1.ReadWriteOnce)
+		if test.tier != "" {
+			pvc.Annotations = map[string]string{TierAnnotation: test.tier}
+		}
+		if missing := MissingTierSuffix(pvc); missing != test.missing {
+			t.Errorf("%s with tier %q: got missing suffix %t instead of %t", test.className, test.tier, missing, test.missing)
+		}
+	}
+}
+
+func TestApplyTierAzureFile(t *testing.T) {
+	tests := []struct {
+		provisioner string
+		tier        string
+		sku         string
+	}{
+		{provisioner: azureFileProvisioner, tier: TierFast, sku: "Premium_LRS"},
+		{provisioner: azureFileProvisioner, tier: TierStandard, sku: "Standard_LRS"},
+		{provisioner: azureFileCSIProvisioner, tier: TierFast, sku: "Premium_LRS"},
+		{provisioner: azureFileCSIProvisioner, tier: TierCold, sku: "Standard_LRS"},
+	}
+	for _, test := range tests {
+		pvc := claim("files-"+test.tier, v1.ReadWriteMany)
+		pvc.Annotations = map[string]string{TierAnnotation: test.tier}
+		provisioner := &v1alpha1.ProvisionerSpec{
+			Provisioner: test.provisioner,
+			Parameters:  map[string]string{skuName: "Standard_GRS"},
+		}
+		parameters, err := ApplyTier(pvc, provisioner, CopyParameters(provisioner))
+		if err != nil {
+			t.Fatalf("%s %s: %s", test.provisioner, test.tier, err.Error())
+		}
+		if parameters[skuName] != test.sku {
+			t.Errorf("%s %s: got skuName %s instead of %s", test.provisioner, test.tier, parameters[skuName], test.sku)
+		}
+	}
+}
+
+func TestApplyTierError(t *testing.T) {
+	tests := []struct {
+		provisioner string
+		tier        string
+		unknown     bool
+	}{
+		{provisioner: "efs.csi.aws.com", tier: TierFast},
+		{provisioner: awsEbsProvisioner, tier: "platinum", unknown: true},
+	}
+	for _, test := range tests {
+		pvc := claim("data", v1.ReadWriteOnce)
+		pvc.Annotations = map[string]string{TierAnnotation: test.tier}
+		_, err := ApplyTier(pvc, &v1alpha1.ProvisionerSpec{Provisioner: test.provisioner}, nil)
+		tierErr, ok := err.(*TierError)
+		if !ok {
+			t.Errorf("%s %s: expected a TierError, got %v", test.provisioner, test.tier, err)
+			continue
+		}
+		if unknown := tierErr.Provisioner == ""; unknown != test.unknown {
+			t.Errorf("%s %s: got unknown tier %t instead of %t", test.provisioner, test.tier, unknown, test.unknown)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package stub
+
+import (
+	"context"
+	"time"
+
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// baseRetryDelay is the first backoff applied to a PVC whose provisioning failed
+	baseRetryDelay = 5 * time.Second
+	// maxRetryDelay caps the exponential backoff of failing PVCs
+	maxRetryDelay = 5 * time.Minute
+	// pendingRequeueDelay is how often a PVC is looked at again while it waits to be bound
+	pendingRequeueDelay = 30 * time.Second
+)
+
+// Run starts workers draining the PersistentVolumeClaim and StorageClass queues and blocks until ctx is done
+func (h *Handler) Run(ctx context.Context, workers int) {
+	defer h.queue.ShutDown()
+	defer h.storageClassQueue.ShutDown()
+	logrus.Infof("Starting %d PersistentVolumeClaim workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.Until(h.runWorker, time.Second, ctx.Done())
+	}
+	go wait.Until(h.runStorageClassWorker, time.Second, ctx.Done())
+	<-ctx.Done()
+	logrus.Info("Stopping PersistentVolumeClaim workers")
+}
+
+// enqueue adds the namespace/name key of the PVC to the work queue
+func (h *Handler) enqueue(pvc *v1.PersistentVolumeClaim) {
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		logrus.Errorf("Could not create key for PersistentVolumeClaim %s", err.Error())
+		return
+	}
+	h.queue.Add(key)
+}
+
+// enqueueStorageClass adds the name of the StorageClass to the garbage collection queue
+func (h *Handler) enqueueStorageClass(name string) {
+	h.storageClassQueue.Add(name)
+}
+
+// setRefused records whether the PVC behind key was refused by a ProvisioningPolicy
+func (h *Handler) setRefused(key string, refused bool) {
+	h.refusedMu.Lock()
+	defer h.refusedMu.Unlock()
+	if refused {
+		h.refused[key] = true
+	} else {
+		delete(h.refused, key)
+	}
+}
+
+// requeueRefused adds the PVCs refused by a ProvisioningPolicy to the work queue again
+func (h *Handler) requeueRefused() {
+	h.refusedMu.Lock()
+	defer h.refusedMu.Unlock()
+	for key := range h.refused {
+		h.queue.Add(key)
+	}
+	h.refused = map[string]bool{}
+}
+
+// runWorker processes PersistentVolumeClaim queue items until the queue is shut down
+func (h *Handler) runWorker() {
+	for processNextItem(h.queue, h.reconcile) {
+	}
+}
+
+// runStorageClassWorker processes StorageClass queue items until the queue is shut down
+func (h *Handler) runStorageClassWorker() {
+	for processNextItem(h.storageClassQueue, h.reconcileStorageClass) {
+	}
+}
+
+// processNextItem reconciles a single key and decides if and when it has to be looked at again
+func processNextItem(queue workqueue.RateLimitingInterface, reconcile func(string) (bool, error)) bool {
+	key, quit := queue.Get()
+	if quit {
+		return false
+	}
+	defer queue.Done(key)
+
+	pending, err := reconcile(key.(string))
+	switch {
+	case err != nil:
+		logrus.Errorf("Reconciling %s failed (retry %d): %s", key, queue.NumRequeues(key), err.Error())
+		queue.AddRateLimited(key)
+	case pending:
+		queue.Forget(key)
+		queue.AddAfter(key, pendingRequeueDelay)
+	default:
+		queue.Forget(key)
+	}
+	return true
+}
+
+// reconcile re-reads the PVC behind key and provisions its storage if it is still pending.
+// It reports whether the PVC is still waiting to be bound.
+func (h *Handler) reconcile(key string) (bool, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		logrus.Errorf("Invalid PersistentVolumeClaim key %s: %s", key, err.Error())
+		return false, nil
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+	if err := h.client.Get(pvc); err != nil {
+		if apierrors.IsNotFound(err) {
+			logrus.Infof("PersistentVolumeClaim %s is gone, dropping it", key)
+			h.setRefused(key, false)
+			return false, nil
+		}
+		return false, err
+	}
+	if pvc.Spec.StorageClassName == nil || pvc.Status.Phase != v1.ClaimPending {
+		h.setRefused(key, false)
+		return false, nil
+	}
+	selector, err := newPVCSelector(config.Get().Selectors)
+	if err != nil {
+		return false, err
+	}
+	selected, reason, err := selector.matches(h.client, pvc)
+	if err != nil {
+		return false, err
+	}
+	if !selected {
+		metrics.SkippedClaims.Inc()
+		logrus.Infof("Skipping PersistentVolumeClaim %s: %s", key, reason)
+		return false, nil
+	}
+	err = h.provision(pvc)
+	if err == errRefused {
+		// recorded once, the PVC or a ProvisioningPolicy changing brings it back
+		h.setRefused(key, true)
+		return false, nil
+	}
+	h.setRefused(key, false)
+	if err == errUnservable {
+		// recorded once, the PVC changing brings it back
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if config.Get().DryRun {
+		// nothing was created, checking again would only repeat the report
+		return false, nil
+	}
+	logrus.Infof("PersistentVolumeClaim %s is still pending, checking again in %s", key, pendingRequeueDelay)
+	return true, nil
+}
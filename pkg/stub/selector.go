@@ -0,0 +1,91 @@
+package stub
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// pvcSelector decides which PVCs the operator acts on, every configured condition has to match
+type pvcSelector struct {
+	annotationKey   string
+	annotationValue string
+	labels          labels.Selector
+	namespaces      labels.Selector
+}
+
+// newPVCSelector parses the configured selectors, unset ones match every PVC
+func newPVCSelector(selectorConfig config.SelectorConfig) (*pvcSelector, error) {
+	selector := &pvcSelector{}
+	if annotation := selectorConfig.Annotation; annotation != "" {
+		parts := strings.SplitN(annotation, "=", 2)
+		selector.annotationKey = parts[0]
+		if len(parts) == 2 {
+			selector.annotationValue = parts[1]
+		}
+	}
+	if value := selectorConfig.PVCLabelSelector; value != "" {
+		parsed, err := labels.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PVC label selector %q: %s", value, err.Error())
+		}
+		selector.labels = parsed
+	}
+	if value := selectorConfig.NamespaceLabelSelector; value != "" {
+		parsed, err := labels.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace label selector %q: %s", value, err.Error())
+		}
+		selector.namespaces = parsed
+	}
+	return selector, nil
+}
+
+// matches checks if the PVC should be handled, if not it tells why
+func (s *pvcSelector) matches(kubeClient client.Client, pvc *v1.PersistentVolumeClaim) (bool, string, error) {
+	if s.annotationKey != "" {
+		value, ok := pvc.Annotations[s.annotationKey]
+		if !ok || (s.annotationValue != "" && value != s.annotationValue) {
+			return false, fmt.Sprintf("missing annotation %s", s.annotationKey), nil
+		}
+	}
+	if s.labels != nil && !s.labels.Matches(labels.Set(pvc.Labels)) {
+		return false, fmt.Sprintf("labels do not match %s", s.labels.String()), nil
+	}
+	if s.namespaces != nil {
+		namespace := &v1.Namespace{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Namespace",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: pvc.Namespace,
+			},
+		}
+		if err := kubeClient.Get(namespace); err != nil {
+			return false, "", err
+		}
+		if !s.namespaces.Matches(labels.Set(namespace.Labels)) {
+			return false, fmt.Sprintf("namespace %s does not match %s", pvc.Namespace, s.namespaces.String()), nil
+		}
+	}
+	return true, "", nil
+}
+
+// Selects checks if the PVC is in a watched namespace and matches the configured selectors
+func (h *Handler) Selects(pvc *v1.PersistentVolumeClaim) (bool, error) {
+	if !config.WatchesNamespace(pvc.Namespace) {
+		return false, nil
+	}
+	selector, err := newPVCSelector(config.Get().Selectors)
+	if err != nil {
+		return false, err
+	}
+	selected, _, err := selector.matches(h.client, pvc)
+	return selected, err
+}
@@ -0,0 +1,128 @@
+package stub
+
+import (
+	"os"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// namespace returns a Namespace with the labels
+func namespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Namespace",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestPVCSelector(t *testing.T) {
+	apiClient := fake.NewClient(
+		namespace("team", map[string]string{"storage": "managed"}),
+		namespace("other", nil),
+	)
+	tests := []struct {
+		name        string
+		selectors   config.SelectorConfig
+		namespace   string
+		annotations map[string]string
+		labels      map[string]string
+		matches     bool
+	}{
+		{name: "no selectors", namespace: "other", matches: true},
+		{name: "annotation present", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator"}, namespace: "other",
+			annotations: map[string]string{"banzaicloud.com/pvc-operator": ""}, matches: true},
+		{name: "annotation missing", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator"}, namespace: "other", matches: false},
+		{name: "annotation value", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator=enabled"}, namespace: "other",
+			annotations: map[string]string{"banzaicloud.com/pvc-operator": "enabled"}, matches: true},
+		{name: "other annotation value", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator=enabled"}, namespace: "other",
+			annotations: map[string]string{"banzaicloud.com/pvc-operator": "disabled"}, matches: false},
+		{name: "labels match", selectors: config.SelectorConfig{PVCLabelSelector: "app in (db, cache)"}, namespace: "other",
+			labels: map[string]string{"app": "db"}, matches: true},
+		{name: "labels do not match", selectors: config.SelectorConfig{PVCLabelSelector: "app in (db, cache)"}, namespace: "other",
+			labels: map[string]string{"app": "web"}, matches: false},
+		{name: "namespace matches", selectors: config.SelectorConfig{NamespaceLabelSelector: "storage=managed"}, namespace: "team", matches: true},
+		{name: "namespace does not match", selectors: config.SelectorConfig{NamespaceLabelSelector: "storage=managed"}, namespace: "other", matches: false},
+		{name: "all match", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator", PVCLabelSelector: "app", NamespaceLabelSelector: "storage"},
+			namespace: "team", annotations: map[string]string{"banzaicloud.com/pvc-operator": "true"}, labels: map[string]string{"app": "db"}, matches: true},
+		{name: "one does not match", selectors: config.SelectorConfig{Annotation: "banzaicloud.com/pvc-operator", PVCLabelSelector: "app", NamespaceLabelSelector: "storage"},
+			namespace: "team", labels: map[string]string{"app": "db"}, matches: false},
+	}
+	for _, test := range tests {
+		selector, err := newPVCSelector(test.selectors)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err.Error())
+		}
+		pvc := pendingClaim(test.namespace, "data", "standard", v1.ReadWriteOnce)
+		pvc.Annotations = test.annotations
+		pvc.Labels = test.labels
+		matches, reason, err := selector.matches(apiClient, pvc)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		if matches != test.matches {
+			t.Errorf("%s: got match %t instead of %t", test.name, matches, test.matches)
+		}
+		if !matches && reason == "" {
+			t.Errorf("%s: a skipped claim has to tell why", test.name)
+		}
+	}
+}
+
+func TestPVCSelectorErrors(t *testing.T) {
+	for _, selectors := range []config.SelectorConfig{
+		{PVCLabelSelector: "app in (db"},
+		{NamespaceLabelSelector: "!="},
+	} {
+		if _, err := newPVCSelector(selectors); err == nil {
+			t.Errorf("%+v: expected an error for an invalid selector", selectors)
+		}
+	}
+	selector, err := newPVCSelector(config.SelectorConfig{NamespaceLabelSelector: "storage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := selector.matches(fake.NewClient(), pendingClaim("missing", "data", "standard", v1.ReadWriteOnce)); err == nil {
+		t.Error("expected an error when the namespace cannot be read")
+	}
+}
+
+func TestSelectsWatchedNamespaces(t *testing.T) {
+	defer useConfig(nil)()
+	previous, set := os.LookupEnv("WATCH_NAMESPACE")
+	os.Setenv("WATCH_NAMESPACE", "team, default")
+	defer func() {
+		if set {
+			os.Setenv("WATCH_NAMESPACE", previous)
+		} else {
+			os.Unsetenv("WATCH_NAMESPACE")
+		}
+	}()
+	handler := NewHandler(fake.NewClient())
+	tests := []struct {
+		namespace string
+		selected  bool
+	}{
+		{namespace: "team", selected: true},
+		{namespace: "default", selected: true},
+		{namespace: "other", selected: false},
+	}
+	for _, test := range tests {
+		selected, err := handler.Selects(pendingClaim(test.namespace, "data", "standard", v1.ReadWriteOnce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selected != test.selected {
+			t.Errorf("%s: got selected %t instead of %t", test.namespace, selected, test.selected)
+		}
+	}
+}
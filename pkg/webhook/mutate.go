@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// storageClassAnnotation is the deprecated way of requesting a StorageClass which is still honored
+	storageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+	// OriginalStorageClassAnnotation records the StorageClass name a PVC requested before it was translated
+	OriginalStorageClassAnnotation = "banzaicloud.com/original-storage-class"
+)
+
+// patchOperation is a JSON patch operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// mutate translates the StorageClass name of the PVC according to the configured class translations and appends the
+// requested tier to it
+func (s *Server) mutate(pvc *v1.PersistentVolumeClaim) *v1beta1.AdmissionResponse {
+	requested, explicit := requestedClassName(pvc)
+	if explicit && requested == "" {
+		// an empty class name turns dynamic provisioning off, it must not be defaulted
+		return allowed()
+	}
+	provider, err := s.backend.Provider()
+	if err != nil {
+		logrus.Warnf("Not translating StorageClass of PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		return allowed()
+	}
+	webhookConfig := config.Get().Webhook
+	className, err := s.translateClassName(provider, pvc, requested, webhookConfig.Classes, webhookConfig.TranslateDefaultClass)
+	if err != nil {
+		logrus.Warnf("Not translating StorageClass of PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		return allowed()
+	}
+	if className == "" {
+		className = requested
+	}
+	if tier, err := providers.ClaimTier(pvc); err == nil && className != "" {
+		// classes of different tiers must not collide, an unknown tier is rejected by validate
+		className = providers.TierClassName(className, tier)
+	}
+	if className == "" || className == requested {
+		return allowed()
+	}
+	patch, err := json.Marshal(classPatch(pvc, requested, className))
+	if err != nil {
+		return denied(err.Error())
+	}
+	logrus.Infof("Translating StorageClass %q of PersistentVolumeClaim %s/%s to %s", requested, pvc.Namespace, pvc.Name, className)
+	patchType := v1beta1.PatchTypeJSONPatch
+	return &v1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// requestedClassName returns the StorageClass name of the PVC and whether it was set at all
+func requestedClassName(pvc *v1.PersistentVolumeClaim) (string, bool) {
+	if pvc.Spec.StorageClassName != nil {
+		return *pvc.Spec.StorageClassName, true
+	}
+	name, ok := pvc.Annotations[storageClassAnnotation]
+	return name, ok
+}
+
+// translateClassName returns the StorageClass name the first matching translation gives, empty if none matches.
+// Translations without a name only apply if translateDefault is set, they match the default StorageClass of the cluster
+// too, as the DefaultStorageClass admission plugin fills it in before the webhook sees claims omitting the StorageClass.
+func (s *Server) translateClassName(provider providers.CommonProvider, pvc *v1.PersistentVolumeClaim, requested string, translations []config.ClassTranslation, translateDefault bool) (string, error) {
+	defaulted := false
+	if translateDefault {
+		var err error
+		if defaulted, err = s.isDefaultClassName(requested); err != nil {
+			return "", err
+		}
+	}
+	for _, translation := range translations {
+		if translation.Name == "" && !translateDefault {
+			continue
+		}
+		if translation.Name != requested && (translation.Name != "" || !defaulted) {
+			continue
+		}
+		if translation.Provider != "" && translation.Provider != provider.Name() {
+			continue
+		}
+		if !requestsAnyOf(pvc, translation.AccessModes) {
+			continue
+		}
+		className := strings.Replace(translation.StorageClassName, config.ProviderPlaceholder, provider.Name(), -1)
+		if translation.NfsFallback {
+			supported, err := providers.SupportsAccessModes(s.client, provider, pvc, className)
+			if err != nil {
+				return "", err
+			}
+			if !supported {
+				className += "-nfs"
+			}
+		}
+		return className, nil
+	}
+	return "", nil
+}
+
+// isDefaultClassName checks if the StorageClass name is the default StorageClass of the cluster
+func (s *Server) isDefaultClassName(className string) (bool, error) {
+	if className == "" {
+		return false, nil
+	}
+	defaultClassName, err := providers.DefaultStorageClassName(s.client)
+	if err != nil {
+		return false, err
+	}
+	return className == defaultClassName, nil
+}
+
+// requestsAnyOf checks if the PVC requests one of the access modes, no access modes match every PVC
+func requestsAnyOf(pvc *v1.PersistentVolumeClaim, modes []v1.PersistentVolumeAccessMode) bool {
+	if len(modes) == 0 {
+		return true
+	}
+	for _, requested := range pvc.Spec.AccessModes {
+		for _, mode := range modes {
+			if requested == mode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classPatch sets the new StorageClass name where the PVC requested one and records the original name
+func classPatch(pvc *v1.PersistentVolumeClaim, requested, className string) []patchOperation {
+	var patch []patchOperation
+	if _, ok := pvc.Annotations[storageClassAnnotation]; ok && pvc.Spec.StorageClassName == nil {
+		patch = append(patch, patchOperation{Op: "replace", Path: annotationPath(storageClassAnnotation), Value: className})
+	} else {
+		patch = append(patch, patchOperation{Op: "add", Path: "/spec/storageClassName", Value: className})
+	}
+	if requested == "" {
+		return patch
+	}
+	if pvc.Annotations == nil {
+		return append(patch, patchOperation{Op: "add", Path: "/metadata/annotations", Value: map[string]string{OriginalStorageClassAnnotation: requested}})
+	}
+	return append(patch, patchOperation{Op: "add", Path: annotationPath(OriginalStorageClassAnnotation), Value: requested})
+}
+
+// annotationPath returns the JSON pointer of the annotation
+func annotationPath(key string) string {
+	return "/metadata/annotations/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
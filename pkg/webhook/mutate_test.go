@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeBackend serves the provider to the webhooks and selects every PVC
+type fakeBackend struct {
+	provider providers.CommonProvider
+}
+
+func (b *fakeBackend) Provider() (providers.CommonProvider, error) {
+	return b.provider, nil
+}
+
+func (b *fakeBackend) Selects(pvc *v1.PersistentVolumeClaim) (bool, error) {
+	return true, nil
+}
+
+// newTestServer returns a Server of the aws provider reading the objects
+func newTestServer(t *testing.T, objects ...sdk.Object) *Server {
+	apiClient := fake.NewClient(objects...)
+	provider, err := providers.NewProvider(apiClient, "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(&fakeBackend{provider: provider}, apiClient)
+}
+
+// classClaim returns a PVC requesting the StorageClass with the access mode, an empty name omits it
+func classClaim(className string, accessMode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "data",
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{accessMode},
+		},
+	}
+	if className != "" {
+		pvc.Spec.StorageClassName = &className
+	}
+	return pvc
+}
+
+// patchedClassName returns the StorageClass name the response patches in, empty if it patches none
+func patchedClassName(t *testing.T, response *v1beta1.AdmissionResponse) string {
+	if !response.Allowed {
+		t.Fatalf("the PersistentVolumeClaim was rejected: %v", response.Result)
+	}
+	var patch []patchOperation
+	if len(response.Patch) != 0 {
+		if err := json.Unmarshal(response.Patch, &patch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, operation := range patch {
+		if operation.Path == "/spec/storageClassName" {
+			return operation.Value.(string)
+		}
+	}
+	return ""
+}
+
+// defaultClass returns gp2 as the default StorageClass of the cluster
+func defaultClass() *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "gp2",
+			Annotations: map[string]string{providers.DefaultStorageClassAnnotation: "true"},
+		},
+		Provisioner: "kubernetes.io/aws-ebs",
+	}
+}
+
+func TestMutateTranslatesPortableNames(t *testing.T) {
+	server := newTestServer(t, defaultClass())
+	tests := []struct {
+		name       string
+		className  string
+		accessMode v1.PersistentVolumeAccessMode
+		translated string
+	}{
+		{name: "omitted", className: "", accessMode: v1.ReadWriteOnce, translated: ""},
+		{name: "filled in by the DefaultStorageClass plugin", className: "gp2", accessMode: v1.ReadWriteOnce, translated: ""},
+		{name: "portable name", className: "shared", accessMode: v1.ReadWriteMany, translated: "aws-shared-nfs"},
+		{name: "other class", className: "fast", accessMode: v1.ReadWriteOnce, translated: ""},
+	}
+	for _, test := range tests {
+		translated := patchedClassName(t, server.mutate(classClaim(test.className, test.accessMode)))
+		if translated != test.translated {
+			t.Errorf("%s: got StorageClass %q instead of %q", test.name, translated, test.translated)
+		}
+	}
+}
+
+func TestMutateTranslatesDefaultStorageClass(t *testing.T) {
+	operatorConfig := config.Default()
+	operatorConfig.Webhook.TranslateDefaultClass = true
+	config.Set(operatorConfig)
+	defer config.Set(config.Default())
+	server := newTestServer(t, defaultClass())
+	tests := []struct {
+		name       string
+		className  string
+		accessMode v1.PersistentVolumeAccessMode
+		translated string
+	}{
+		{name: "omitted", className: "", accessMode: v1.ReadWriteOnce, translated: "aws-standard"},
+		{name: "filled in by the DefaultStorageClass plugin", className: "gp2", accessMode: v1.ReadWriteOnce, translated: "aws-standard"},
+		{name: "shared default", className: "gp2", accessMode: v1.ReadWriteMany, translated: "aws-shared-nfs"},
+		{name: "portable name", className: "shared", accessMode: v1.ReadWriteMany, translated: "aws-shared-nfs"},
+		{name: "other class", className: "fast", accessMode: v1.ReadWriteOnce, translated: ""},
+	}
+	for _, test := range tests {
+		translated := patchedClassName(t, server.mutate(classClaim(test.className, test.accessMode)))
+		if translated != test.translated {
+			t.Errorf("%s: got StorageClass %q instead of %q", test.name, translated, test.translated)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxRequestSize bounds the AdmissionReview bodies read
+const maxRequestSize = 1 << 20
+
+// Backend is what the webhooks need from the operator
+type Backend interface {
+	// Provider returns the detected cloud provider
+	Provider() (providers.CommonProvider, error)
+	// Selects checks if the operator handles the PVC
+	Selects(pvc *v1.PersistentVolumeClaim) (bool, error)
+}
+
+// Server serves the admission webhooks of the operator
+type Server struct {
+	backend Backend
+	client  client.Client
+}
+
+// NewServer creates a Server answering admission requests with the help of backend, it reads the StorageClasses and
+// StorageProfiles with apiClient
+func NewServer(backend Backend, apiClient client.Client) *Server {
+	return &Server{backend: backend, client: apiClient}
+}
+
+// Serve serves the webhooks over TLS until it fails
+func (s *Server) Serve(webhookConfig config.WebhookConfig) error {
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", s.review("mutate", s.mutate))
+	mux.Handle("/validate", s.review("validate", s.validate))
+	logrus.Infof("Serving admission webhooks on %s", webhookConfig.Address)
+	return http.ListenAndServeTLS(webhookConfig.Address, webhookConfig.CertFile, webhookConfig.KeyFile, mux)
+}
+
+// review decodes the AdmissionReview, passes the PersistentVolumeClaim in it to admit and writes the response
+func (s *Server) review(name string, admit func(*v1.PersistentVolumeClaim) *v1beta1.AdmissionResponse) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := &v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+		metrics.EventsHandled.WithLabelValues("AdmissionReview").Inc()
+		response := s.admit(review.Request, admit)
+		response.UID = review.Request.UID
+		review.Response = response
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(review); err != nil {
+			logrus.Errorf("Could not write %s response: %s", name, err.Error())
+		}
+	})
+}
+
+// admit calls admit for PersistentVolumeClaims being created, everything else is allowed unchanged
+func (s *Server) admit(request *v1beta1.AdmissionRequest, admit func(*v1.PersistentVolumeClaim) *v1beta1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	if request.Kind.Kind != "PersistentVolumeClaim" || request.Operation != v1beta1.Create {
+		return allowed()
+	}
+	pvc := &v1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(request.Object.Raw, pvc); err != nil {
+		return denied(fmt.Sprintf("invalid PersistentVolumeClaim: %s", err.Error()))
+	}
+	if pvc.Namespace == "" {
+		pvc.Namespace = request.Namespace
+	}
+	selected, err := s.backend.Selects(pvc)
+	if err != nil {
+		logrus.Errorf("Could not check the selectors of PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		return allowed()
+	}
+	if !selected {
+		return allowed()
+	}
+	return admit(pvc)
+}
+
+// allowed admits the request unchanged
+func allowed() *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{Allowed: true}
+}
+
+// denied rejects the request with the message
+func denied(message string) *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: message,
+		},
+	}
+}
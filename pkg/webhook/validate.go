@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"fmt"
+
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/metrics"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/events"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+)
+
+// validate rejects, or only warns about, PVCs requesting an unknown tier, another tier or encryption than their existing
+// class was created for and PVCs whose access modes the provider cannot serve with the requested class unless the Nfs
+// fallback is enabled
+func (s *Server) validate(pvc *v1.PersistentVolumeClaim) *v1beta1.AdmissionResponse {
+	tier, err := providers.ClaimTier(pvc)
+	if err != nil {
+		return reject(fmt.Sprintf("PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error()), events.UnknownTier)
+	}
+	if classTier, mismatch := providers.TierMismatch(s.client, pvc); mismatch {
+		message := fmt.Sprintf("StorageClass %s of PersistentVolumeClaim %s/%s was created for tier %q, not for the requested %s, use StorageClass %s instead",
+			*pvc.Spec.StorageClassName, pvc.Namespace, pvc.Name, classTier, tier, providers.TierClassName(*pvc.Spec.StorageClassName, tier))
+		return reject(message, events.TierMismatch)
+	}
+	if reason, mismatch := providers.EncryptionMismatch(s.client, pvc); mismatch {
+		return reject(fmt.Sprintf("PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, reason), events.EncryptionKeyInvalid)
+	}
+	className, _ := requestedClassName(pvc)
+	if className == "" || providers.CheckStorageClassExistence(s.client, className) {
+		// existing classes are served by their own provisioner, the operator only creates missing ones
+		return allowed()
+	}
+	provider, err := s.backend.Provider()
+	if err != nil {
+		logrus.Warnf("Not validating PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		return allowed()
+	}
+	supported, err := providers.SupportsAccessModes(s.client, provider, pvc, className)
+	if err != nil {
+		logrus.Warnf("Not validating PersistentVolumeClaim %s/%s: %s", pvc.Namespace, pvc.Name, err.Error())
+		return allowed()
+	}
+	if supported {
+		return allowed()
+	}
+	if config.Get().Nfs.Fallback {
+		logrus.Infof("PersistentVolumeClaim %s/%s will be served by Nfs as %s cannot serve access modes %v with StorageClass %s",
+			pvc.Namespace, pvc.Name, provider.Name(), pvc.Spec.AccessModes, className)
+		return allowed()
+	}
+	message := fmt.Sprintf("access modes %v of PersistentVolumeClaim %s/%s are not supported on %s with StorageClass %s, use an NFS StorageClass like %s-nfs instead",
+		pvc.Spec.AccessModes, pvc.Namespace, pvc.Name, provider.Name(), className, className)
+	return reject(message, events.AccessModeNotSupported)
+}
+
+// reject denies the request with the message, or only warns about it with the Warn validation mode
+func reject(message, reason string) *v1beta1.AdmissionResponse {
+	metrics.Errors.WithLabelValues(reason).Inc()
+	if config.Get().Webhook.Validation == config.ValidationWarn {
+		logrus.Warn(message)
+		return allowed()
+	}
+	logrus.Info(message)
+	return denied(message)
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateRejectsTierMismatch(t *testing.T) {
+	fastClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "data",
+			Labels: map[string]string{
+				providers.ManagedByLabel: providers.ManagedByValue,
+				providers.TierAnnotation: providers.TierFast,
+			},
+		},
+		Provisioner: "kubernetes.io/aws-ebs",
+	}
+	server := newTestServer(t, fastClass)
+	tests := []struct {
+		tier    string
+		allowed bool
+	}{
+		{tier: "", allowed: true},
+		{tier: providers.TierFast, allowed: true},
+		{tier: providers.TierCold, allowed: false},
+		{tier: "turbo", allowed: false},
+	}
+	for _, test := range tests {
+		pvc := classClaim("data", v1.ReadWriteOnce)
+		if test.tier != "" {
+			pvc.Annotations = map[string]string{providers.TierAnnotation: test.tier}
+		}
+		if response := server.validate(pvc); response.Allowed != test.allowed {
+			t.Errorf("tier %q: got allowed %t instead of %t", test.tier, response.Allowed, test.allowed)
+		}
+	}
+}
+
+func TestValidateRejectsEncryptionMismatch(t *testing.T) {
+	plainClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "gp2",
+		},
+		Provisioner: "kubernetes.io/aws-ebs",
+		Parameters:  map[string]string{"type": "gp2"},
+	}
+	server := newTestServer(t, plainClass)
+	pvc := classClaim("gp2", v1.ReadWriteOnce)
+	if response := server.validate(pvc); !response.Allowed {
+		t.Errorf("a claim without encryption was rejected: %v", response.Result)
+	}
+	pvc.Annotations = map[string]string{providers.EncryptedAnnotation: "true"}
+	if response := server.validate(pvc); response.Allowed {
+		t.Error("a claim requesting encryption from an unencrypted StorageClass was admitted")
+	}
+}
@@ -0,0 +1,5 @@
+// Package e2e drives the operator handler against an in-memory API server and fake metadata servers and cloud APIs,
+// so it runs on any Linux box without a cluster or cloud account:
+//
+//	go test -tags e2e -v ./test/e2e
+package e2e
@@ -0,0 +1,326 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/banzaicloud/pvc-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAzureDisk(t *testing.T) {
+	env := startEnvironment(t, "azure", nil)
+	defer env.stop()
+	if err := env.claim("data", "azure-rwo", v1.ReadWriteOnce); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("azure-rwo", "kubernetes.io/azure-disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.Parameters["kind"] != "managed" {
+		t.Errorf("expected a managed disk, got parameters %v", storageClass.Parameters)
+	}
+}
+
+func TestAzureFileWithNewStorageAccount(t *testing.T) {
+	env := startEnvironment(t, "azure", nil)
+	defer env.stop()
+	if err := env.claim("shared", "azure-rwx", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("azure-rwx", "kubernetes.io/azure-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := storageClass.Parameters["storageAccount"]
+	if account == "" || storageClass.Parameters["location"] != azureLocation {
+		t.Fatalf("expected a storage account in %s, got parameters %v", azureLocation, storageClass.Parameters)
+	}
+	if !env.azureStorage.hasAccount(account) {
+		t.Errorf("storage account %s was not created", account)
+	}
+	accountPath := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", azureSubscriptionID, azureResourceGroup, account)
+	if !env.azureStorage.received("PUT", accountPath) {
+		t.Errorf("storage account was not created in resource group %s", azureResourceGroup)
+	}
+}
+
+func TestAzureFilePremiumTier(t *testing.T) {
+	env := startEnvironment(t, "azure", nil)
+	defer env.stop()
+	if err := env.annotatedClaim("shared", "azure-rwx-fast", v1.ReadWriteMany, map[string]string{providers.TierAnnotation: providers.TierFast}); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("azure-rwx-fast", "kubernetes.io/azure-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := storageClass.Parameters["storageAccount"]
+	if sku, kind := env.azureStorage.accountSku(account); sku != "Premium_LRS" || kind != "FileStorage" {
+		t.Errorf("expected a Premium_LRS FileStorage account for tier fast, got %s %s", sku, kind)
+	}
+}
+
+func TestAzureFileReusesStorageAccount(t *testing.T) {
+	env := startEnvironment(t, "azure", nil)
+	defer env.stop()
+	// the account of the class was created before, e.g. by an attempt failing later
+	hash := sha256.Sum256([]byte(azureSubscriptionID + "/" + azureResourceGroup + "/azure-rwx"))
+	account := "pvc" + hex.EncodeToString(hash[:])[:21]
+	env.azureStorage.addAccount(account)
+	if err := env.claim("shared", "azure-rwx", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("azure-rwx", "kubernetes.io/azure-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.Parameters["storageAccount"] != account {
+		t.Fatalf("expected storage account %s, got parameters %v", account, storageClass.Parameters)
+	}
+	accountPath := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s", azureSubscriptionID, azureResourceGroup, account)
+	if env.azureStorage.received("PUT", accountPath) {
+		t.Errorf("existing storage account %s was created again", account)
+	}
+}
+
+func TestAwsEbsThroughIMDSv2(t *testing.T) {
+	env := startEnvironment(t, "aws", nil)
+	defer env.stop()
+	if err := env.claim("data", "aws-rwo", v1.ReadWriteOnce); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.expectProvisioner("aws-rwo", "kubernetes.io/aws-ebs"); err != nil {
+		t.Fatal(err)
+	}
+	if !env.metadata.received("PUT", "/latest/api/token") {
+		t.Error("no IMDSv2 session token was requested")
+	}
+}
+
+func TestAwsEncryptionWithKeyOfRegion(t *testing.T) {
+	env := startEnvironment(t, "aws", nil)
+	defer env.stop()
+	key := "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	if err := env.annotatedClaim("secret", "aws-encrypted", v1.ReadWriteOnce, map[string]string{providers.EncryptionKeyAnnotation: key}); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("aws-encrypted", "kubernetes.io/aws-ebs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.Parameters["encrypted"] != "true" || storageClass.Parameters["kmsKeyId"] != key {
+		t.Errorf("StorageClass aws-encrypted is not encrypted with %s: %v", key, storageClass.Parameters)
+	}
+	otherRegion := "arn:aws:kms:us-east-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	if err := env.annotatedClaim("elsewhere", "aws-elsewhere", v1.ReadWriteOnce, map[string]string{providers.EncryptionKeyAnnotation: otherRegion}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.noStorageClass("aws-elsewhere"); err != nil {
+		t.Errorf("%s with a key of another region", err.Error())
+	}
+}
+
+func TestGooglePersistentDisk(t *testing.T) {
+	env := startEnvironment(t, "google", nil)
+	defer env.stop()
+	if err := env.claim("data", "google-rwo", v1.ReadWriteOnce); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := env.expectProvisioner("google-rwo", "kubernetes.io/gce-pd"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoogleFastTier(t *testing.T) {
+	env := startEnvironment(t, "google", nil)
+	defer env.stop()
+	annotations := map[string]string{providers.TierAnnotation: providers.TierFast}
+	if err := env.annotatedClaim("fast", "gce-fast", v1.ReadWriteOnce, annotations); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("gce-fast", "kubernetes.io/gce-pd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.Parameters["type"] != "pd-ssd" {
+		t.Errorf("StorageClass gce-fast has disk type %q instead of pd-ssd", storageClass.Parameters["type"])
+	}
+	if storageClass.Labels[providers.TierAnnotation] != providers.TierFast {
+		t.Errorf("StorageClass gce-fast is not labeled with tier %s", providers.TierFast)
+	}
+}
+
+func TestGoogleBucketForObjectStore(t *testing.T) {
+	env := startEnvironment(t, "google", nil)
+	defer env.stop()
+	store := &v1alpha1.ObjectStore{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ObjectStore",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "backups",
+			Namespace: "e2e",
+		},
+		Spec: v1alpha1.ObjectStoreSpec{
+			Name: "e2e-backups",
+		},
+	}
+	if err := env.handler.Handle(sdk.Context{Context: env.ctx}, sdk.Event{Object: store}); err != nil {
+		t.Fatal(err)
+	}
+	if project := env.googleStorage.bucketProject("e2e-backups"); project != googleProject {
+		t.Errorf("expected bucket e2e-backups in project %s, got %q", googleProject, project)
+	}
+}
+
+func TestNfsStackForNfsClass(t *testing.T) {
+	env := startEnvironment(t, "aws", nil)
+	defer env.stop()
+	if err := env.claim("shared", "shared-nfs", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.expectNfsStack("shared-nfs"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalPathForReadWriteOnce(t *testing.T) {
+	env := startEnvironment(t, "local", nil)
+	defer env.stop()
+	if err := env.claim("data", "local-rwo", v1.ReadWriteOnce); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.expectProvisioner("local-rwo", "rancher.io/local-path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.VolumeBindingMode == nil || *storageClass.VolumeBindingMode != storagev1.VolumeBindingWaitForFirstConsumer {
+		t.Error("StorageClass local-rwo does not wait for the first consumer")
+	}
+}
+
+func TestLocalNfsForReadWriteMany(t *testing.T) {
+	env := startEnvironment(t, "local", nil)
+	defer env.stop()
+	if err := env.claim("shared", "local-rwx", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.expectNfsStack("local-rwx"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNfsFallbackOnAws(t *testing.T) {
+	env := startEnvironment(t, "aws", func(operatorConfig *config.Config) {
+		operatorConfig.Nfs.Fallback = true
+	})
+	defer env.stop()
+	if err := env.claim("shared", "aws-shared", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.expectNfsStack("aws-shared"); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.storageClass("aws-shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.Annotations[providers.NfsFallbackAnnotation] == "" {
+		t.Errorf("StorageClass aws-shared has no %s annotation", providers.NfsFallbackAnnotation)
+	}
+	pvc := &v1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "e2e"},
+	}
+	if err := env.client.Get(pvc); err != nil {
+		t.Fatal(err)
+	}
+	if pvc.Annotations[providers.NfsFallbackAnnotation] == "" {
+		t.Errorf("PersistentVolumeClaim e2e/shared has no %s annotation", providers.NfsFallbackAnnotation)
+	}
+}
+
+func TestDryRunCreatesNothing(t *testing.T) {
+	env := startEnvironment(t, "azure", func(operatorConfig *config.Config) {
+		operatorConfig.DryRun = true
+	})
+	defer env.stop()
+	if err := env.claim("shared", "azure-rwx", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	err := waitFor(func() bool {
+		return env.metadata.received("GET", "/metadata/instance/compute/resourceGroupName")
+	}, "the Azure metadata to be read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.noStorageClass("azure-rwx"); err != nil {
+		t.Errorf("%s in dry-run mode", err.Error())
+	}
+	if requests := env.azureStorage.all(); len(requests) != 0 {
+		t.Errorf("the Azure storage API was called in dry-run mode: %v", requests)
+	}
+}
+
+func TestNfsBackingVolumeGrowsWithClaims(t *testing.T) {
+	env := startEnvironment(t, "aws", nil)
+	defer env.stop()
+	allowVolumeExpansion := true
+	defaultClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{Kind: "StorageClass", APIVersion: "storage.k8s.io/v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "gp2",
+			Annotations: map[string]string{providers.DefaultStorageClassAnnotation: "true"},
+		},
+		Provisioner:          "kubernetes.io/aws-ebs",
+		AllowVolumeExpansion: &allowVolumeExpansion,
+	}
+	if err := env.client.Create(defaultClass); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.claim("shared", "shared-nfs", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.expectNfsStack("shared-nfs"); err != nil {
+		t.Fatal(err)
+	}
+	storageClass, err := env.storageClass("shared-nfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageClass.AllowVolumeExpansion == nil || *storageClass.AllowVolumeExpansion {
+		t.Error("StorageClass shared-nfs allows volume expansion the Nfs provisioner cannot do")
+	}
+	if err := env.claim("shared-2", "shared-nfs", v1.ReadWriteMany); err != nil {
+		t.Fatal(err)
+	}
+	dataClaim := &v1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "shared-nfs-data", Namespace: config.Get().Nfs.Namespace},
+	}
+	expected := resource.MustParse("4Gi")
+	err = waitFor(func() bool {
+		if err := env.client.Get(dataClaim); err != nil {
+			return false
+		}
+		size := dataClaim.Spec.Resources.Requests[v1.ResourceStorage]
+		return size.Cmp(expected) == 0
+	}, "PersistentVolumeClaim shared-nfs-data to grow to %s", expected.String())
+	if err != nil {
+		t.Error(err)
+	}
+}
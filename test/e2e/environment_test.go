@@ -0,0 +1,215 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/pvc-operator/pkg/client/fake"
+	"github.com/banzaicloud/pvc-operator/pkg/config"
+	"github.com/banzaicloud/pvc-operator/pkg/stub"
+	"github.com/banzaicloud/pvc-operator/pkg/stub/providers"
+	"github.com/operator-framework/operator-sdk/pkg/sdk"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/apps/v1beta1"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// waitTimeout bounds how long a test waits for the workers to create the expected objects
+const waitTimeout = 10 * time.Second
+
+// settleTime is how long a test waits for the workers before checking that nothing was created
+const settleTime = time.Second
+
+// environment is the fake world a test runs in
+type environment struct {
+	ctx           context.Context
+	client        *fake.Client
+	handler       *stub.Handler
+	metadata      recorder
+	azureStorage  fakeAzureStorage
+	googleStorage fakeGoogleStorage
+	stop          func()
+}
+
+// startEnvironment starts the fakes of the cloud and the handler, configure adjusts the operator configuration and is
+// optional. The returned environment has to be stopped.
+func startEnvironment(t *testing.T, cloud string, configure func(operatorConfig *config.Config)) *environment {
+	if !testing.Verbose() {
+		logrus.SetLevel(logrus.WarnLevel)
+	}
+	env := &environment{client: fake.NewClient()}
+	var servers []*httptest.Server
+	var metadataServer *httptest.Server
+	switch cloud {
+	case "azure":
+		metadataServer = newAzureMetadataServer(&env.metadata)
+	case "aws":
+		metadataServer = newAWSMetadataServer(&env.metadata)
+	case "google":
+		metadataServer = newGCEMetadataServer(&env.metadata)
+	}
+	azureServer := newAzureStorageServer(&env.azureStorage)
+	googleServer := newGoogleStorageServer(&env.googleStorage)
+	servers = append(servers, azureServer, googleServer)
+
+	operatorConfig := config.Default()
+	operatorConfig.ProviderDetection = []string{config.DetectionMetadata}
+	operatorConfig.Endpoints.AzureResourceManager = azureServer.URL
+	operatorConfig.Endpoints.GoogleStorage = googleServer.URL
+	// the fake Google Cloud Storage server takes no credentials
+	operatorConfig.Endpoints.GoogleStorageWithoutAuthentication = true
+	if metadataServer != nil {
+		servers = append(servers, metadataServer)
+		operatorConfig.Endpoints.Metadata = metadataServer.URL
+	} else {
+		operatorConfig.Provider = cloud
+	}
+	if configure != nil {
+		configure(operatorConfig)
+	}
+	config.Set(operatorConfig)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	env.ctx = ctx
+	env.stop = func() {
+		cancel()
+		for _, server := range servers {
+			server.Close()
+		}
+		config.Set(config.Default())
+	}
+	env.handler = stub.NewHandler(env.client)
+	if err := env.handler.DetectProvider(); err != nil {
+		env.stop()
+		t.Fatalf("provider detection failed: %s", err.Error())
+	}
+	go env.handler.Run(ctx, 1)
+	return env
+}
+
+// claim creates a pending PVC and hands its event to the handler
+func (env *environment) claim(name, className string, accessMode v1.PersistentVolumeAccessMode) error {
+	return env.annotatedClaim(name, className, accessMode, nil)
+}
+
+// annotatedClaim creates a pending PVC with the annotations and hands its event to the handler
+func (env *environment) annotatedClaim(name, className string, accessMode v1.PersistentVolumeAccessMode, annotations map[string]string) error {
+	pvc := &v1.PersistentVolumeClaim{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PersistentVolumeClaim",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "e2e",
+			Annotations: annotations,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: &className,
+			AccessModes:      []v1.PersistentVolumeAccessMode{accessMode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse("1Gi")},
+			},
+		},
+		Status: v1.PersistentVolumeClaimStatus{
+			Phase: v1.ClaimPending,
+		},
+	}
+	if err := env.client.Create(pvc); err != nil {
+		return err
+	}
+	return env.handler.Handle(sdk.Context{Context: env.ctx}, sdk.Event{Object: pvc})
+}
+
+// storageClass waits for the StorageClass to be created
+func (env *environment) storageClass(name string) (*storagev1.StorageClass, error) {
+	storageClass := &storagev1.StorageClass{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "StorageClass",
+			APIVersion: "storage.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+	return storageClass, waitFor(func() bool {
+		return env.client.Get(storageClass) == nil
+	}, "StorageClass %s", name)
+}
+
+// noStorageClass waits for the workers to settle and checks that the StorageClass was not created
+func (env *environment) noStorageClass(name string) error {
+	time.Sleep(settleTime)
+	storageClass := &storagev1.StorageClass{
+		TypeMeta:   metav1.TypeMeta{Kind: "StorageClass", APIVersion: "storage.k8s.io/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	if err := env.client.Get(storageClass); err == nil {
+		return fmt.Errorf("StorageClass %s was created", name)
+	}
+	return nil
+}
+
+// expectProvisioner waits for the StorageClass and checks its provisioner and operator labels
+func (env *environment) expectProvisioner(className, provisioner string) (*storagev1.StorageClass, error) {
+	storageClass, err := env.storageClass(className)
+	if err != nil {
+		return nil, err
+	}
+	if storageClass.Provisioner != provisioner {
+		return nil, fmt.Errorf("StorageClass %s has provisioner %s instead of %s", className, storageClass.Provisioner, provisioner)
+	}
+	if !providers.IsManagedStorageClass(storageClass) {
+		return nil, fmt.Errorf("StorageClass %s is not labeled as managed", className)
+	}
+	return storageClass, nil
+}
+
+// expectNfsStack waits for the Nfs StorageClass and checks the Deployment, Service and data PVC behind it
+func (env *environment) expectNfsStack(className string) error {
+	if _, err := env.expectProvisioner(className, providers.NfsProvisioner); err != nil {
+		return err
+	}
+	namespace := config.Get().Nfs.Namespace
+	objects := []sdk.Object{
+		&v1beta1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "extensions/v1beta1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs-provisioner", Namespace: namespace},
+		},
+		&v1.Service{
+			TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "nfs-provisioner", Namespace: namespace},
+		},
+		&v1.PersistentVolumeClaim{
+			TypeMeta:   metav1.TypeMeta{Kind: "PersistentVolumeClaim", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: className + "-data", Namespace: namespace},
+		},
+	}
+	for _, object := range objects {
+		if err := env.client.Get(object); err != nil {
+			return fmt.Errorf("%T of the Nfs stack is missing: %s", object, err.Error())
+		}
+	}
+	return nil
+}
+
+// waitFor polls the condition until it holds or waitTimeout passes
+func waitFor(condition func() bool, format string, args ...interface{}) error {
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for "+format, args...)
+}
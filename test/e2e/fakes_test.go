@@ -0,0 +1,275 @@
+//go:build e2e
+// +build e2e
+
+package e2e
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values served by the fake metadata servers
+const (
+	azureLocation       = "westeurope"
+	azureSubscriptionID = "00000000-0000-0000-0000-000000000000"
+	azureResourceGroup  = "pvc-operator-e2e"
+	azureAccessToken    = "fake-msi-token"
+	awsToken            = "fake-imdsv2-token"
+	awsZone             = "eu-west-1a"
+	googleProject       = "pvc-operator-e2e"
+	googleZone          = "europe-west1-b"
+)
+
+// recorder remembers the requests a fake server received
+type recorder struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+// record remembers the request
+func (r *recorder) record(req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+}
+
+// received checks if a request with the method and path was received
+func (r *recorder) received(method, path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, request := range r.requests {
+		if request == method+" "+path {
+			return true
+		}
+	}
+	return false
+}
+
+// all returns the received requests
+func (r *recorder) all() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.requests...)
+}
+
+// newAzureMetadataServer imitates the Azure Instance Metadata Service including the managed identity token endpoint
+func newAzureMetadataServer(requests *recorder) *httptest.Server {
+	values := map[string]string{
+		"location":          azureLocation,
+		"subscriptionId":    azureSubscriptionID,
+		"resourceGroupName": azureResourceGroup,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/instance/compute/", func(w http.ResponseWriter, req *http.Request) {
+		requests.record(req)
+		if req.Header.Get("Metadata") != "true" || req.URL.Query().Get("api-version") == "" {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		value, ok := values[strings.TrimPrefix(req.URL.Path, "/metadata/instance/compute/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, value)
+	})
+	mux.HandleFunc("/metadata/identity/oauth2/token", func(w http.ResponseWriter, req *http.Request) {
+		requests.record(req)
+		now := time.Now()
+		writeJSON(w, map[string]string{
+			"access_token":  azureAccessToken,
+			"refresh_token": "",
+			"expires_in":    "3600",
+			"expires_on":    fmt.Sprintf("%d", now.Add(time.Hour).Unix()),
+			"not_before":    fmt.Sprintf("%d", now.Unix()),
+			"resource":      req.URL.Query().Get("resource"),
+			"token_type":    "Bearer",
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+// newAWSMetadataServer imitates the EC2 instance metadata service enforcing IMDSv2 session tokens
+func newAWSMetadataServer(requests *recorder) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, req *http.Request) {
+		requests.record(req)
+		if req.Method != "PUT" || req.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, awsToken)
+	})
+	mux.HandleFunc("/latest/meta-data/placement/availability-zone", func(w http.ResponseWriter, req *http.Request) {
+		requests.record(req)
+		if req.Header.Get("X-aws-ec2-metadata-token") != awsToken {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, awsZone)
+	})
+	return httptest.NewServer(mux)
+}
+
+// newGCEMetadataServer imitates the v1 API of the Compute Engine metadata server
+func newGCEMetadataServer(requests *recorder) *httptest.Server {
+	values := map[string]string{
+		"project/project-id": googleProject,
+		"instance/zone":      "projects/123456789/zones/" + googleZone,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/computeMetadata/v1/", func(w http.ResponseWriter, req *http.Request) {
+		requests.record(req)
+		if req.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "Missing Metadata-Flavor:Google header", http.StatusForbidden)
+			return
+		}
+		value, ok := values[strings.TrimPrefix(req.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Metadata-Flavor", "Google")
+		fmt.Fprint(w, value)
+	})
+	return httptest.NewServer(mux)
+}
+
+// fakeAzureStorage imitates the storage account operations of the Azure Resource Manager API
+type fakeAzureStorage struct {
+	recorder
+	accountsMu sync.Mutex
+	// accounts holds the SKU and kind of the storage accounts
+	accounts map[string][2]string
+}
+
+// newAzureStorageServer starts a fake Azure Resource Manager API
+func newAzureStorageServer(storage *fakeAzureStorage) *httptest.Server {
+	storage.accounts = map[string][2]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		storage.record(req)
+		if req.Header.Get("Authorization") != "Bearer "+azureAccessToken {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == "POST" && strings.HasSuffix(req.URL.Path, "/providers/Microsoft.Storage/checkNameAvailability"):
+			writeJSON(w, map[string]interface{}{"nameAvailable": true})
+		case strings.Contains(req.URL.Path, "/providers/Microsoft.Storage/storageAccounts/"):
+			name := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+			storage.accountsMu.Lock()
+			defer storage.accountsMu.Unlock()
+			if req.Method == "PUT" {
+				account := struct {
+					Sku  struct{ Name string }
+					Kind string
+				}{}
+				if err := json.NewDecoder(req.Body).Decode(&account); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				storage.accounts[name] = [2]string{account.Sku.Name, account.Kind}
+			} else if _, ok := storage.accounts[name]; !ok {
+				writeStatusJSON(w, http.StatusNotFound, map[string]interface{}{
+					"error": map[string]string{"code": "ResourceNotFound", "message": "storage account " + name + " not found"},
+				})
+				return
+			}
+			writeJSON(w, map[string]interface{}{
+				"id":       req.URL.Path,
+				"name":     name,
+				"location": azureLocation,
+				"properties": map[string]string{
+					"provisioningState": "Succeeded",
+				},
+			})
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+// hasAccount checks if the storage account was created
+func (s *fakeAzureStorage) hasAccount(name string) bool {
+	s.accountsMu.Lock()
+	defer s.accountsMu.Unlock()
+	_, ok := s.accounts[name]
+	return ok
+}
+
+// accountSku returns the SKU and kind the storage account was created with
+func (s *fakeAzureStorage) accountSku(name string) (string, string) {
+	s.accountsMu.Lock()
+	defer s.accountsMu.Unlock()
+	return s.accounts[name][0], s.accounts[name][1]
+}
+
+// addAccount adds a storage account which exists before the operator starts
+func (s *fakeAzureStorage) addAccount(name string) {
+	s.accountsMu.Lock()
+	defer s.accountsMu.Unlock()
+	s.accounts[name] = [2]string{"Standard_LRS", "Storage"}
+}
+
+// fakeGoogleStorage imitates the bucket operations of the Google Cloud Storage JSON API
+type fakeGoogleStorage struct {
+	recorder
+	bucketsMu sync.Mutex
+	buckets   map[string]string
+}
+
+// newGoogleStorageServer starts a fake Google Cloud Storage JSON API
+func newGoogleStorageServer(storage *fakeGoogleStorage) *httptest.Server {
+	storage.buckets = map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		storage.record(req)
+		if req.Method != "POST" || req.URL.Path != "/storage/v1/b" {
+			http.NotFound(w, req)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bucket := map[string]interface{}{}
+		if err := json.Unmarshal(body, &bucket); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, _ := bucket["name"].(string)
+		storage.bucketsMu.Lock()
+		storage.buckets[name] = req.URL.Query().Get("project")
+		storage.bucketsMu.Unlock()
+		bucket["kind"] = "storage#bucket"
+		bucket["id"] = name
+		writeJSON(w, bucket)
+	}))
+}
+
+// bucketProject returns the project the bucket was created in, empty if it does not exist
+func (s *fakeGoogleStorage) bucketProject(name string) string {
+	s.bucketsMu.Lock()
+	defer s.bucketsMu.Unlock()
+	return s.buckets[name]
+}
+
+// writeJSON writes value as a JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	writeStatusJSON(w, http.StatusOK, value)
+}
+
+// writeStatusJSON answers with the status and value encoded as JSON
+func writeStatusJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}